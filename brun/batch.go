@@ -6,78 +6,180 @@ import (
 
 // Batch provides a means by which to execute several goroutines in parallel.
 type Batch struct {
-	queue fnQueue
+	// Name identifies the batch in metrics.
+	Name string
+
+	// PanicsAsErrors makes Run return a *PanicError when a job panics, rather
+	// than re-panicking. See SetPanicsAsErrors to enable this globally.
+	PanicsAsErrors bool
+
+	// Logger receives any failures that can't be returned from Run. If nil,
+	// the package-level logger is used.
+	Logger Logger
+
+	// Metrics receives measurements of the batch's jobs. If nil, the
+	// package-level sink is used, if any.
+	Metrics Metrics
+
+	// Limit is the maximum number of jobs that will be run at once. Jobs past
+	// the limit wait in the order they were added until a running job
+	// completes. If zero or negative, every job is started immediately.
+	Limit int
+
+	queue memberQueue
 }
 
 // Add a job to the batch that will be called when `Exec` is invoked.
 func (b *Batch) Add(fn func(ctx context.Context) error) {
-	b.queue.push(fn)
+	b.queue.push(member{fn: fn})
 }
 
 // Run performs all queued actions. Any errors from a queued function will
-// immediately cancel all jobs and return.
+// immediately cancel all jobs and return. Errors from any other jobs that fail
+// for reasons besides that cancellation are returned alongside it in a
+// *MultiError. Jobs that are still waiting on the limit when the batch is
+// cancelled will never be started.
 func (b *Batch) Run(ctx context.Context) error {
+	// todo (bs): consider setting a value here to ensure no double-runs.
+
+	queue := b.queue.getFns()
+	return performBatchRun(ctx, b.config(), b.Limit, queue)
+}
+
+// RunAll performs all queued actions like Run, but rather than failing fast
+// it lets every job run to completion regardless of how the others fare. The
+// outcome of each job is returned at the same index as the order it was added,
+// with nil marking success. If ctx is cancelled, any jobs that were still
+// waiting on the limit are not started, and their outcome is ctx's error.
+//
+// As with Run, a panicking job will be re-raised once the other jobs are done
+// unless panics are returned as errors, in which case its outcome is a
+// *PanicError.
+func (b *Batch) RunAll(ctx context.Context) []error {
+	queue := b.queue.getFns()
+	results, _ := performBatchRunMode(ctx, b.config(), b.Limit, false, queue)
+	return results
+}
+
+func (b *Batch) config() runConfig {
+	cfg := defaultRunConfig()
+	cfg.panicsAsErrors = cfg.panicsAsErrors || b.PanicsAsErrors
+	cfg.logger = getLogger(b.Logger)
+	cfg.name = b.Name
+	cfg.metrics = getMetrics(b.Metrics)
+	return cfg
+}
+
+// BatchRun runs the given functions together as a batch with no limit on their
+// parallelism.
+func BatchRun(
+	ctx context.Context,
+	fns ...func(ctx context.Context) error,
+) error {
+	return performBatchRun(ctx, defaultRunConfig(), 0, fns)
+}
+
+// BatchRunLimit runs the given functions together as a batch, with at most
+// limit of them running at a time.
+func BatchRunLimit(
+	ctx context.Context,
+	limit int,
+	fns ...func(ctx context.Context) error,
+) error {
+	return performBatchRun(ctx, defaultRunConfig(), limit, fns)
+}
+
+func performBatchRun(
+	ctx context.Context,
+	cfg runConfig,
+	limit int,
+	fns []func(ctx context.Context) error,
+) error {
+	_, err := performBatchRunMode(ctx, cfg, limit, true, fns)
+	return err
+}
+
+// performBatchRunMode runs the batch, returning the outcome of each job
+// alongside the result of the batch as a whole. If failFast is set, the first
+// failure cancels every other job.
+func performBatchRunMode(
+	ctx context.Context,
+	cfg runConfig,
+	limit int,
+	failFast bool,
+	fns []func(ctx context.Context) error,
+) ([]error, error) {
+	ctx, task := traceRun(ctx, kindBatch)
+	defer task.End()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	// todo (bs): consider setting a value here to ensure no double-runs.
-
 	// note (bs): should do a little more research to guarantee this is safe. I'd
 	// bet it is, but now that waitgroup is not used my original research into the
 	// matter is not valid.
 
-	queue := b.queue.get()
-	errChan := make(chan runErr, len(queue))
-
-	for _, queuedFn := range queue {
-		fn := queuedFn
-		go func() {
-			var err error
-			defer func() {
-				if r := recover(); r != nil {
-					errChan <- runErr{
-						panic: r,
-					}
-				} else {
-					errChan <- runErr{
-						err: err,
-					}
-				}
-			}()
-			err = execErrFnInContext(ctx, fn)
-		}()
+	if limit <= 0 || limit > len(fns) {
+		limit = len(fns)
+	}
+
+	// errChan only needs to hold a value from each job that can be running at
+	// once, as nothing new is started until a value has been read.
+	errChan := make(chan runErr, limit)
+	metrics := newMemberMetrics(cfg.metrics, kindBatch, cfg.name)
+	startJob := func(index int) {
+		fn := fns[index]
+		spawn(ctx, kindBatch, index, "", func(ctx context.Context) {
+			errChan <- execMember(ctx, metrics, index, "", fn)
+		})
 	}
 
-	var firstErr error
+	next, running := 0, 0
+	for ; next < limit; next++ {
+		startJob(next)
+		running++
+	}
+
+	results := make([]error, len(fns))
+	var errs errSet
 	var firstPanic interface{}
-	for range queue {
-		select {
-		case re := <-errChan:
-			if re.panic != nil {
-				cancel()
-				if firstPanic == nil {
-					firstPanic = re.panic
-				} else {
-					// todo (bs): consider logging the returned value here; perhaps with a
-					// globally configurable logger (that could of course be set to mute
-					// logs)
-				}
-			} else if re.err != nil {
-				cancel()
-				if firstErr == nil {
-					firstErr = re.err
-				} else if re.err != context.Canceled {
-					// todo (bs): if the error is not a context error and a default logger
-					// has been configured, consider using the default logger here.
-				}
+	for running > 0 {
+		re := <-errChan
+		running--
+		if re.panic != nil && cfg.panicsAsErrors {
+			results[re.index] = re.panicError()
+			errs.addPanic(results[re.index])
+		} else if re.panic != nil {
+			if firstPanic == nil {
+				firstPanic = re.panic
+			} else {
+				logSuppressedPanic(cfg.logger, re)
 			}
+		} else if re.err != nil {
+			results[re.index] = re.err
+			errs.add(ctx, re.err)
 		}
+		if failFast && (re.panic != nil || re.err != nil) {
+			cancel()
+		}
+
+		// Once the batch has been cancelled, either by a failure or by the caller,
+		// the remaining jobs are abandoned rather than started.
+		if next < len(fns) && ctx.Err() == nil {
+			startJob(next)
+			next++
+			running++
+		}
+	}
+	for ; next < len(fns); next++ {
+		results[next] = ctx.Err()
 	}
+
 	if firstPanic != nil {
+		logSuppressedErrs(cfg.logger, errs)
 		panic(firstPanic)
 	}
-	if firstErr != nil {
-		return firstErr
+	if err := errs.err(); err != nil {
+		return results, err
 	}
-	return ctx.Err()
+	return results, ctx.Err()
 }
@@ -3,6 +3,7 @@ package brun
 import (
 	"context"
 	"errors"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -116,3 +117,135 @@ func Test_panicBatch(t *testing.T) {
 		t.Fatal("Expected panic to be propagated; got ", execPanic)
 	}
 }
+
+func Test_limitBatch(t *testing.T) {
+	t.Run("capsParallelism", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		b := Batch{Limit: 2}
+		var running, maxRunning, runCount int64
+		for i := 0; i < 10; i++ {
+			b.Add(func(ctx context.Context) error {
+				now := atomic.AddInt64(&running, 1)
+				defer atomic.AddInt64(&running, -1)
+				atomic.AddInt64(&runCount, 1)
+				for {
+					prev := atomic.LoadInt64(&maxRunning)
+					if now <= prev || atomic.CompareAndSwapInt64(&maxRunning, prev, now) {
+						break
+					}
+				}
+				time.Sleep(2 * time.Millisecond)
+				return nil
+			})
+		}
+
+		if err := b.Run(ctx); err != nil {
+			t.Fatalf("Error in batch run: %s", err)
+		}
+		if v := atomic.LoadInt64(&runCount); v != 10 {
+			t.Fatalf("Expected 10 jobs to run; got %d", v)
+		}
+		if v := atomic.LoadInt64(&maxRunning); v != 2 {
+			t.Fatalf("Expected at most 2 jobs at once; got %d", v)
+		}
+	})
+
+	t.Run("abandonsQueuedJobsOnError", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		baseErr := errors.New("an error")
+		var runCount int64
+		err := BatchRunLimit(
+			ctx,
+			1,
+			func(ctx context.Context) error {
+				atomic.AddInt64(&runCount, 1)
+				return baseErr
+			},
+			func(ctx context.Context) error {
+				atomic.AddInt64(&runCount, 1)
+				return nil
+			},
+		)
+
+		if err != baseErr {
+			t.Fatalf("Expected error to propagate; got %v", err)
+		}
+		if v := atomic.LoadInt64(&runCount); v != 1 {
+			t.Fatalf("Expected queued job to never start; %d ran", v)
+		}
+	})
+}
+
+func Test_runAllBatch(t *testing.T) {
+	t.Run("collectsEveryOutcome", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		b := Batch{Limit: 2}
+		err1, err3 := errors.New("err1"), errors.New("err3")
+		var runCount int64
+		for _, err := range []error{nil, err1, nil, err3, nil} {
+			jobErr := err
+			b.Add(func(ctx context.Context) error {
+				atomic.AddInt64(&runCount, 1)
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return jobErr
+			})
+		}
+		results := b.RunAll(ctx)
+
+		if v := atomic.LoadInt64(&runCount); v != 5 {
+			t.Fatalf("Expected every job to run; got %d", v)
+		}
+		expected := []error{nil, err1, nil, err3, nil}
+		for i, err := range results {
+			if err != expected[i] {
+				t.Fatalf("Unexpected outcome at index %d: %v", i, err)
+			}
+		}
+	})
+
+	t.Run("panicsAsErrors", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		b := Batch{PanicsAsErrors: true}
+		b.Add(func(ctx context.Context) error {
+			panic("job panic")
+		})
+		b.Add(func(ctx context.Context) error {
+			return nil
+		})
+		results := b.RunAll(ctx)
+
+		var panicErr *PanicError
+		if !errors.As(results[0], &panicErr) || results[1] != nil {
+			t.Fatalf("Unexpected outcomes: %v", results)
+		}
+	})
+
+	t.Run("cancelledBeforeStart", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		b := Batch{Limit: 1}
+		b.Add(func(ctx context.Context) error {
+			return ctx.Err()
+		})
+		b.Add(func(ctx context.Context) error {
+			t.Error("job should not have been started")
+			return nil
+		})
+		results := b.RunAll(ctx)
+
+		if results[0] != context.Canceled || results[1] != context.Canceled {
+			t.Fatalf("Expected cancellation outcomes; got %v", results)
+		}
+	})
+}
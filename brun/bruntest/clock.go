@@ -0,0 +1,139 @@
+package bruntest
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// FakeClock is a clock that only moves when advanced, for use with
+// brun.WithClock. It lets tests of retries and timeouts run instantly and
+// deterministically: rather than sleeping, a test waits for the code under
+// test to start a timer with BlockUntil, then fires it with Advance.
+type FakeClock struct {
+	l       sync.Mutex
+	changed *sync.Cond
+	now     time.Time
+	timers  []*fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// NewFakeClock returns a fake clock set to the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.changed = sync.NewCond(&c.l)
+	return c
+}
+
+// Now returns the clock's current time.
+func (c *FakeClock) Now() time.Time {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.now
+}
+
+// NewTimer starts a timer that fires once the clock has been advanced by d. A
+// timer for zero or less fires immediately.
+func (c *FakeClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	t := &fakeTimer{
+		at: c.now.Add(d),
+		c:  make(chan time.Time, 1),
+	}
+	if d <= 0 {
+		t.c <- c.now
+		return t.c, func() bool { return false }
+	}
+	c.timers = append(c.timers, t)
+	c.changed.Broadcast()
+	return t.c, func() bool {
+		return c.stop(t)
+	}
+}
+
+// Advance moves the clock forward by d, firing every timer that falls due in
+// order.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	end := c.now.Add(d)
+	for {
+		next := c.nextTimer()
+		if next == nil || next.at.After(end) {
+			break
+		}
+		c.now = next.at
+		c.remove(next)
+		next.c <- c.now
+	}
+	c.now = end
+	c.changed.Broadcast()
+}
+
+// Pending returns how far the clock must be advanced to fire each timer that
+// has been started but has neither fired nor been stopped, soonest first.
+func (c *FakeClock) Pending() []time.Duration {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	pending := make([]time.Duration, len(c.timers))
+	for i, t := range c.timers {
+		pending[i] = t.at.Sub(c.now)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i] < pending[j]
+	})
+	return pending
+}
+
+// BlockUntil waits until at least n timers are pending. This is how a test
+// knows the code under test has reached a wait, and can safely advance the
+// clock past it.
+func (c *FakeClock) BlockUntil(n int) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	for len(c.timers) < n {
+		c.changed.Wait()
+	}
+}
+
+func (c *FakeClock) stop(t *fakeTimer) bool {
+	c.l.Lock()
+	defer c.l.Unlock()
+	removed := c.remove(t)
+	if removed {
+		c.changed.Broadcast()
+	}
+	return removed
+}
+
+// nextTimer returns the timer that's due soonest, if any. Must be called with
+// the lock held.
+func (c *FakeClock) nextTimer() *fakeTimer {
+	var next *fakeTimer
+	for _, t := range c.timers {
+		if next == nil || t.at.Before(next.at) {
+			next = t
+		}
+	}
+	return next
+}
+
+// remove drops the timer from those pending, returning false if it wasn't.
+// Must be called with the lock held.
+func (c *FakeClock) remove(t *fakeTimer) bool {
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
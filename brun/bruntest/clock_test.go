@@ -0,0 +1,96 @@
+package bruntest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bennettjames/go-concurrency-experiments/brun"
+)
+
+var _ brun.Clock = (*FakeClock)(nil)
+
+func Test_FakeClock(t *testing.T) {
+	start := time.Unix(0, 0)
+
+	t.Run("firesTimersInOrder", func(t *testing.T) {
+		c := NewFakeClock(start)
+		late, _ := c.NewTimer(20 * time.Millisecond)
+		early, _ := c.NewTimer(10 * time.Millisecond)
+
+		c.Advance(15 * time.Millisecond)
+		select {
+		case at := <-early:
+			if at != start.Add(10*time.Millisecond) {
+				t.Fatalf("unexpected fire time: %s", at)
+			}
+		default:
+			t.Fatalf("expected early timer to fire")
+		}
+		select {
+		case <-late:
+			t.Fatalf("expected late timer not to fire yet")
+		default:
+		}
+		if now := c.Now(); now != start.Add(15*time.Millisecond) {
+			t.Fatalf("unexpected time: %s", now)
+		}
+
+		c.Advance(5 * time.Millisecond)
+		if at := <-late; at != start.Add(20*time.Millisecond) {
+			t.Fatalf("unexpected fire time: %s", at)
+		}
+	})
+
+	t.Run("reportsAndStopsPendingTimers", func(t *testing.T) {
+		c := NewFakeClock(start)
+		c.NewTimer(30 * time.Millisecond)
+		fired, stop := c.NewTimer(10 * time.Millisecond)
+		c.Advance(5 * time.Millisecond)
+
+		pending := c.Pending()
+		if len(pending) != 2 || pending[0] != 5*time.Millisecond || pending[1] != 25*time.Millisecond {
+			t.Fatalf("unexpected pending timers: %v", pending)
+		}
+		if !stop() || stop() {
+			t.Fatalf("expected only the first stop to succeed")
+		}
+		c.Advance(10 * time.Millisecond)
+		select {
+		case <-fired:
+			t.Fatalf("expected stopped timer not to fire")
+		default:
+		}
+		if pending := c.Pending(); len(pending) != 1 {
+			t.Fatalf("unexpected pending timers: %v", pending)
+		}
+	})
+
+	t.Run("firesNonPositiveTimersImmediately", func(t *testing.T) {
+		c := NewFakeClock(start)
+		fired, _ := c.NewTimer(0)
+		if at := <-fired; at != start {
+			t.Fatalf("unexpected fire time: %s", at)
+		}
+		if pending := c.Pending(); len(pending) != 0 {
+			t.Fatalf("unexpected pending timers: %v", pending)
+		}
+	})
+
+	t.Run("blocksUntilTimersArePending", func(t *testing.T) {
+		c := NewFakeClock(start)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			c.BlockUntil(2)
+		}()
+
+		c.NewTimer(10 * time.Millisecond)
+		select {
+		case <-done:
+			t.Fatalf("expected to still be blocked with one timer")
+		case <-time.After(5 * time.Millisecond):
+		}
+		c.NewTimer(10 * time.Millisecond)
+		<-done
+	})
+}
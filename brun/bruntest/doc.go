@@ -0,0 +1,2 @@
+// Package bruntest provides utilities for testing code built on brun.
+package bruntest
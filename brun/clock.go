@@ -0,0 +1,50 @@
+package brun
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is the source of time for brun's timing code: retry delays, shutdown
+// timeouts, restart windows and the times recorded in reports. It can be
+// replaced with WithClock, which allows tests to control time rather than
+// wait on it; see the bruntest package for a fake implementation.
+//
+// Context deadlines are unaffected, and always follow real time.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// NewTimer starts a timer that sends the current time on the returned
+	// channel once d has passed. The returned function stops the timer, and
+	// reports whether it was stopped before it fired.
+	NewTimer(d time.Duration) (<-chan time.Time, func() bool)
+}
+
+// WithClock returns a context under which brun uses the given clock in place
+// of real time.
+func WithClock(ctx context.Context, clock Clock) context.Context {
+	return context.WithValue(ctx, clockKey{}, clock)
+}
+
+type clockKey struct{}
+
+// getClock returns the clock set on ctx, falling back to real time.
+func getClock(ctx context.Context) Clock {
+	if clock, ok := ctx.Value(clockKey{}).(Clock); ok && clock != nil {
+		return clock
+	}
+	return realClock{}
+}
+
+// realClock implements Clock with the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	timer := time.NewTimer(d)
+	return timer.C, timer.Stop
+}
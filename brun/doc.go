@@ -1,6 +1,6 @@
 //
 // Package brun offers a few helper utilities to manage groups of goroutines.
-// There are three main utilities:
+// There are four main utilities:
 //
 // - Group. This is designed for a set of long-running goroutines that are
 // expected to run in concert. It ensures a uniform runtime with safe shutdown.
@@ -8,8 +8,11 @@
 // - Batch. This is for ensuring a set of short lived tasks are all executed and
 // completed together, complete with error management.
 //
-// - Set. This is for dynamic sets of tasks that may come and go. Has not
-// intrinsic error handling of the goroutines; as they are inherently
-// independent.
+// - Set. This is for dynamic sets of tasks that may come and go. Tasks are
+// independent, so one returning doesn't affect the rest; a panic in any of
+// them does stop the set, and is propagated from Run.
+//
+// - Supervisor. This is for long-running goroutines that should be restarted
+// when they exit, with limits on how often that may happen before giving up.
 //
 package brun
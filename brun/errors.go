@@ -0,0 +1,205 @@
+package brun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MultiError is returned by a run when more than one member failed with an
+// error other than a cancellation. It reports itself and unwraps as the first
+// such error, so callers that only care about a single failure can keep
+// treating it as one; errors.Is and errors.As will match against every
+// contained error.
+type MultiError struct {
+	Errors []error
+}
+
+func (e *MultiError) Error() string {
+	switch len(e.Errors) {
+	case 0:
+		return "brun: no errors"
+	case 1:
+		return e.Errors[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", e.Errors[0], len(e.Errors)-1)
+}
+
+// Unwrap returns the first error, if any.
+func (e *MultiError) Unwrap() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e.Errors[0]
+}
+
+// Is reports whether any of the contained errors matches target.
+func (e *MultiError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first contained error that matches target.
+func (e *MultiError) As(target interface{}) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ShutdownTimeoutError is returned when members of a run failed to exit within
+// the configured shutdown timeout after being cancelled. The members listed
+// are still running when it is returned; it is up to the caller to decide
+// whether that warrants exiting the process.
+type ShutdownTimeoutError struct {
+	// Timeout is the shutdown timeout that was exceeded.
+	Timeout time.Duration
+	// Err is what caused the shutdown.
+	Err error
+	// Stragglers are the members that were still running.
+	Stragglers []Straggler
+}
+
+// Straggler identifies a member that didn't exit before a shutdown timeout.
+type Straggler struct {
+	// Member is the index of the straggling member.
+	Member int
+	// Name is the name or key of the straggling member, if it has one.
+	Name string
+	// Stack is the stack trace of the member's goroutine at the time the
+	// timeout expired, if it could be found.
+	Stack []byte
+}
+
+func (s Straggler) String() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return strconv.Itoa(s.Member)
+}
+
+func (e *ShutdownTimeoutError) Error() string {
+	names := make([]string, len(e.Stragglers))
+	for i, s := range e.Stragglers {
+		names[i] = s.String()
+	}
+	return fmt.Sprintf(
+		"brun: %d members still running %s after shutdown (%s): %s",
+		len(e.Stragglers), e.Timeout, e.Err, strings.Join(names, ", "))
+}
+
+// Unwrap returns the error that caused the shutdown.
+func (e *ShutdownTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// RestartLimitError is returned by a Supervisor that exceeded its restart
+// intensity.
+type RestartLimitError struct {
+	// Child is the name of the child whose exit exceeded the limit.
+	Child string
+	// MaxRestarts and Window are the restart intensity that was exceeded.
+	MaxRestarts int
+	Window      time.Duration
+	// Err is how the child exited.
+	Err error
+}
+
+func (e *RestartLimitError) Error() string {
+	return fmt.Sprintf(
+		"brun: supervisor exceeded %d restarts in %s; child %q exited with: %v",
+		e.MaxRestarts, e.Window, e.Child, e.Err)
+}
+
+// Unwrap returns how the child exited.
+func (e *RestartLimitError) Unwrap() error {
+	return e.Err
+}
+
+// RetryError is returned by a Retrier, and the retry helpers built on it, when
+// it stops retrying while the function is still failing.
+type RetryError struct {
+	// Attempts is how many times the function was run.
+	Attempts int
+	// Err is why retrying stopped: the context's error, or ErrRetryLimit.
+	Err error
+	// Last is the error returned by the most recent attempt.
+	Last error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("brun: gave up after %d attempts (%s); last error: %s",
+		e.Attempts, e.Err, e.Last)
+}
+
+// Unwrap returns why retrying stopped.
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// errSet accumulates the errors returned by the members of a single run.
+type errSet struct {
+	// first is the first error seen of any kind, including cancellations.
+	first error
+	// errs holds every error that was not caused by cancellation, in the order
+	// they were received.
+	errs []error
+}
+
+// add records err. The given context should be the one the erroring member
+// ran under, checked before any cancellation the error itself triggers - this
+// is what decides if the error is just a symptom of shutdown.
+func (s *errSet) add(ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+	if s.first == nil {
+		s.first = err
+	}
+	if !isCancelErr(ctx, err) {
+		s.errs = append(s.errs, err)
+	}
+}
+
+// addPanic records a member's panic. It always counts as a failure, even if
+// the panic's value was a context error.
+func (s *errSet) addPanic(err error) {
+	if s.first == nil {
+		s.first = err
+	}
+	s.errs = append(s.errs, err)
+}
+
+// err returns the result of the run: nil if nothing failed, the only real
+// error if there was exactly one, a *MultiError if there were several, and
+// otherwise the first cancellation error that was seen.
+func (s *errSet) err() error {
+	switch len(s.errs) {
+	case 0:
+		return s.first
+	case 1:
+		return s.errs[0]
+	}
+	errs := make([]error, len(s.errs))
+	copy(errs, s.errs)
+	return &MultiError{Errors: errs}
+}
+
+// isCancelErr indicates if err is a context error that arose from ctx being
+// done, rather than a failure of its own.
+func isCancelErr(ctx context.Context, err error) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+	return errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
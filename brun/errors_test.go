@@ -0,0 +1,132 @@
+package brun
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_MultiError(t *testing.T) {
+	t.Run("matchesEveryError", func(t *testing.T) {
+		err1, err2 := errors.New("err1"), errors.New("err2")
+		var err error = &MultiError{Errors: []error{err1, err2}}
+
+		if !errors.Is(err, err1) || !errors.Is(err, err2) {
+			t.Fatalf("expected both errors to match, got %s", err)
+		}
+		if errors.Unwrap(err) != err1 {
+			t.Fatalf("expected to unwrap to first error, got %s", errors.Unwrap(err))
+		}
+		if err.Error() != "err1 (and 1 more errors)" {
+			t.Fatalf("unexpected message: %s", err)
+		}
+	})
+
+	t.Run("as", func(t *testing.T) {
+		pathErr := &os.PathError{Op: "open", Path: "/nope", Err: os.ErrNotExist}
+		var err error = &MultiError{Errors: []error{errors.New("other"), pathErr}}
+
+		var target *os.PathError
+		if !errors.As(err, &target) || target != pathErr {
+			t.Fatalf("expected to find path error, got %v", target)
+		}
+	})
+}
+
+func Test_runErrorAggregation(t *testing.T) {
+	t.Run("groupCollectsAllErrors", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		symptomErr, causeErr := errors.New("symptom"), errors.New("cause")
+		err := GroupRun(
+			ctx,
+			func(ctx context.Context) error {
+				return symptomErr
+			},
+			func(ctx context.Context) error {
+				<-ctx.Done()
+				return causeErr
+			},
+			func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		)
+
+		var multiErr *MultiError
+		if !errors.As(err, &multiErr) {
+			t.Fatalf("expected multi-error, got %s", err)
+		}
+		if len(multiErr.Errors) != 2 {
+			t.Fatalf("expected cancellations to be dropped, got %s", multiErr.Errors)
+		}
+		if !errors.Is(err, symptomErr) || !errors.Is(err, causeErr) {
+			t.Fatalf("expected both errors to be present, got %s", err)
+		}
+	})
+
+	t.Run("batchCollectsAllErrors", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		err1, err2 := errors.New("err1"), errors.New("err2")
+		b := Batch{}
+		b.Add(func(ctx context.Context) error {
+			return err1
+		})
+		b.Add(func(ctx context.Context) error {
+			<-ctx.Done()
+			return err2
+		})
+		err := b.Run(ctx)
+
+		if !errors.Is(err, err1) || !errors.Is(err, err2) {
+			t.Fatalf("expected both errors to be present, got %s", err)
+		}
+	})
+
+	t.Run("singleErrorIsNotWrapped", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		innerErr := errors.New("inner")
+		err := GroupRun(
+			ctx,
+			func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			func(ctx context.Context) error {
+				return innerErr
+			},
+		)
+		if err != innerErr {
+			t.Fatalf("expected lone error to be returned as-is, got %s", err)
+		}
+	})
+
+	t.Run("memberTimeoutIsNotCancellation", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		err := GroupRun(
+			ctx,
+			func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			func(ctx context.Context) error {
+				ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+				defer cancel()
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		)
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expected member deadline error, got %s", err)
+		}
+	})
+}
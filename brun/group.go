@@ -2,36 +2,158 @@ package brun
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Group is a way to execute a set of long-running service together.
 type Group struct {
-	queue fnQueue
+	// Name identifies the group in metrics.
+	Name string
+
+	// PanicsAsErrors makes Run return a *PanicError when a member panics,
+	// rather than re-panicking. See SetPanicsAsErrors to enable this globally.
+	PanicsAsErrors bool
+
+	// Logger receives any failures that can't be returned from Run. If nil,
+	// the package-level logger is used.
+	Logger Logger
+
+	// Metrics receives measurements of the group's members. If nil, the
+	// package-level sink is used, if any.
+	Metrics Metrics
+
+	// ShutdownTimeout bounds how long Run will wait for members to exit once the
+	// group begins shutting down. If exceeded, Run returns a
+	// *ShutdownTimeoutError naming the cancelled members still running along
+	// with their goroutine stacks, and leaves them running. Members that were
+	// still waiting on them to exit are cancelled but not reported. If zero,
+	// Run waits indefinitely.
+	ShutdownTimeout time.Duration
+
+	queue memberQueue
+
+	readinessL sync.Mutex
+	readiness  *groupReadiness
 }
 
 // Add will include the given function
 func (g *Group) Add(fn func(ctx context.Context) error) {
-	g.queue.push(fn)
+	g.queue.push(member{fn: fn})
+}
+
+// AddNamed will include the given function under a name. The name is used to
+// identify the member in errors and shutdown reports.
+func (g *Group) AddNamed(name string, fn func(ctx context.Context) error) {
+	g.queue.push(member{name: name, fn: fn})
+}
+
+// AddMember will include the given member. Unlike members added with Add or
+// AddNamed, it may declare dependencies on other members: see Member.
+func (g *Group) AddMember(m Member) {
+	g.queue.push(member{
+		name:      m.Name,
+		fn:        m.Run,
+		dependsOn: m.DependsOn,
+		optional:  m.Optional,
+	})
 }
 
 // Run executes every stored function in parallel. Upon cancellation or a stored
 // function returning/panicing, all stored functions will receive a cancellation
-// in their context. Once all functions have returned, this will then complete
-// with either a panic, an unexpected error, or a cancellation error, depending
-// on how the termination occurs.
+// in their context; optional members are the exception, as their returning
+// alone doesn't end the group. Once all functions have returned, this will
+// then complete with either a panic, an unexpected error, or a cancellation
+// error, depending on how the termination occurs. If more than one function
+// returns an error that isn't a cancellation, they are all returned together
+// as a *MultiError.
+//
+// If any members were added with dependencies, they are instead started in
+// order, with each member waiting until all its dependencies are ready. They
+// are likewise shut down in reverse order: each layer of members is cancelled
+// and waited on before the members they depend on are cancelled.
 func (g *Group) Run(ctx context.Context) error {
+	_, err := g.RunReport(ctx)
+	return err
+}
+
+// RunReport runs the group exactly like Run, and also returns a report of how
+// each member ran and exited. If a member panics and panics are not returned
+// as errors, the panic is re-raised and no report is produced.
+func (g *Group) RunReport(ctx context.Context) (*ShutdownReport, error) {
+	return g.runReport(ctx, g.config())
+}
+
+func (g *Group) runReport(
+	ctx context.Context,
+	cfg runConfig,
+) (*ShutdownReport, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	queue := g.queue.get()
-	return performGroupRun(ctx, queue)
+	return performGroupRun(ctx, cfg, g.claimReadiness(queue), queue)
+}
+
+// Ready returns a channel that is closed once every member of the group has
+// signalled it is ready with ReadyFunc. Before the group is run, this refers
+// to its next run; afterwards, to the most recent one. If some members never
+// become ready, it is never closed; see NotReady.
+func (g *Group) Ready() <-chan struct{} {
+	g.readinessL.Lock()
+	defer g.readinessL.Unlock()
+	if g.readiness == nil {
+		g.readiness = newGroupReadiness()
+	}
+	return g.readiness.done
+}
+
+// NotReady lists the members of the group's current or most recent run that
+// have not signalled they are ready, by name or by index if unnamed. Before the
+// group is run, it lists every member.
+func (g *Group) NotReady() []string {
+	g.readinessL.Lock()
+	defer g.readinessL.Unlock()
+	if g.readiness == nil || !g.readiness.claimed {
+		queue := g.queue.get()
+		labels := make([]string, len(queue))
+		for i, m := range queue {
+			labels[i] = memberLabel(i, m.name)
+		}
+		return labels
+	}
+	return g.readiness.notReady()
+}
+
+// claimReadiness returns the readiness tracker for a new run of the given
+// members, replacing the previous run's.
+func (g *Group) claimReadiness(members []member) *groupReadiness {
+	g.readinessL.Lock()
+	defer g.readinessL.Unlock()
+	if g.readiness == nil || !g.readiness.claim(members) {
+		g.readiness = newGroupReadiness()
+		g.readiness.claim(members)
+	}
+	return g.readiness
+}
+
+func (g *Group) config() runConfig {
+	cfg := defaultRunConfig()
+	cfg.panicsAsErrors = cfg.panicsAsErrors || g.PanicsAsErrors
+	cfg.logger = getLogger(g.Logger)
+	cfg.shutdownTimeout = g.ShutdownTimeout
+	cfg.name = g.Name
+	cfg.metrics = getMetrics(g.Metrics)
+	return cfg
 }
 
 func GroupRunner(
 	fns ...func(context.Context) error,
 ) func(context.Context) error {
 	return func(ctx context.Context) error {
-		return performGroupRun(ctx, fns)
+		_, err := performGroupRun(ctx, defaultRunConfig(), nil, unnamedMembers(fns))
+		return err
 	}
 }
 
@@ -39,91 +161,352 @@ func GroupRun(
 	ctx context.Context,
 	fns ...func(ctx context.Context) error,
 ) error {
-	return performGroupRun(ctx, fns)
+	_, err := performGroupRun(ctx, defaultRunConfig(), nil, unnamedMembers(fns))
+	return err
 }
 
+// performGroupRun runs the members as a group. If readiness is given, it is
+// updated as members signal they are ready.
 func performGroupRun(
 	ctx context.Context,
-	fns []func(ctx context.Context) error,
-) error {
+	cfg runConfig,
+	readiness *groupReadiness,
+	members []member,
+) (*ShutdownReport, error) {
+	ctx, task := traceRun(ctx, kindGroup)
+	defer task.End()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	// If the group is empty, use a dummy that exits upon cancellation.
-	if len(fns) == 0 {
-		fns = []func(ctx context.Context) error{
-			func(ctx context.Context) error {
-				<-ctx.Done()
-				return ctx.Err()
-			},
-		}
-	}
-
-	// errChan is large enough to hold response values from every member of the
-	// queue. This ensures that even when not every message is processed, no
-	// goroutine is blocked on writing to an orphaned channel.
-	//
-	// ques (bs): does this still need to be this size now that all returns are
-	// mandated to be returned? Possibly not.
-	errChan := make(chan runErr, len(fns))
-
-	for _, queuedFn := range fns {
-		fn := queuedFn
-		go func() {
-			var err error
-			defer func() {
-				if r := recover(); r != nil {
-					errChan <- runErr{
-						panic: r,
-					}
-				} else if err != nil {
-					errChan <- runErr{
-						err: err,
-					}
-				} else {
-					errChan <- runErr{}
-				}
-			}()
-			err = execErrFnInContext(ctx, fn)
-		}()
-	}
-
-	// todo (bs): while I like the idea of keeping this dependency-free, I think
-	// multi-error would make more sense rather than trying to side-load a
-	var firstErr error
-	var firstPanic interface{}
-	for range fns {
+	report := &ShutdownReport{
+		Members: make([]MemberReport, len(members)),
+	}
+	for i, m := range members {
+		report.Members[i] = MemberReport{
+			Index:    i,
+			Name:     m.name,
+			Optional: m.optional,
+		}
+	}
+
+	// If the group is empty, it's ready from the start, and there's nothing to
+	// do but wait for cancellation.
+	if len(members) == 0 {
+		ReadyFunc(ctx)()
+		<-ctx.Done()
+		return report, ctx.Err()
+	}
+
+	deps, levels, err := planMembers(members)
+	if err != nil {
+		return report, err
+	}
+
+	r := &groupRun{
+		ctx:     ctx,
+		cfg:     cfg,
+		members: members,
+		deps:    deps,
+		levels:  levels,
+		report:  report,
+		clock:   getClock(ctx),
+		metrics: newMemberMetrics(cfg.metrics, kindGroup, cfg.name),
+
+		readiness: readiness,
+
+		// errChan and readyChan are large enough to hold a value from every
+		// member. This ensures that even when not every message is processed,
+		// no goroutine is blocked on writing to an orphaned channel.
+		errChan:   make(chan runErr, len(members)),
+		readyChan: make(chan int, len(members)),
+
+		memberCtxs:   make([]*memberContext, len(members)),
+		started:      make([]bool, len(members)),
+		ready:        make([]bool, len(members)),
+		exited:       make([]bool, len(members)),
+		goroutineIDs: make([]int64, len(members)),
+	}
+	for _, level := range levels {
+		if level > r.cancelLevel {
+			r.cancelLevel = level
+		}
+	}
+	return r.run()
+}
+
+// groupRun holds the state of a single run of a group's members.
+type groupRun struct {
+	ctx     context.Context
+	cfg     runConfig
+	members []member
+	deps    [][]int
+	levels  []int
+	report  *ShutdownReport
+
+	errChan   chan runErr
+	readyChan chan int
+	readiness *groupReadiness
+	numReady  int
+
+	memberCtxs []*memberContext
+	started    []bool
+	ready      []bool
+	exited     []bool
+	running    int
+
+	// goroutineIDs holds the ID of each member's goroutine, so that the stacks
+	// of stuck members can be reported. Only gathered if there's a timeout.
+	goroutineIDs []int64
+
+	errs       errSet
+	firstPanic interface{}
+
+	// Once shutting down, members are cancelled a level at a time, starting
+	// with cancelLevel and working down to zero.
+	shuttingDown bool
+	shutdownErr  error
+	cancelLevel  int
+
+	metrics         memberMetrics
+	clock           Clock
+	shutdownTimeout <-chan time.Time
+	stopShutdown    func() bool
+	timeoutErr      *ShutdownTimeoutError
+}
+
+func (r *groupRun) run() (*ShutdownReport, error) {
+	defer func() {
+		if r.stopShutdown != nil {
+			r.stopShutdown()
+		}
+		// Members are cancelled when the run ends no matter how it does, so
+		// that any left behind by a timeout still see the cancellation.
+		for _, memberCtx := range r.memberCtxs {
+			if memberCtx != nil {
+				memberCtx.cancel(context.Canceled)
+			}
+		}
+	}()
+
+	r.startReadyMembers()
+	groupDone := r.ctx.Done()
+	// Until shutdown, the group runs even with nothing running, as optional
+	// members may have exited or be left waiting on them.
+	for (r.running > 0 || !r.shuttingDown) && r.timeoutErr == nil {
 		select {
-		case re := <-errChan:
-			cancel()
-			if re.panic != nil {
-				if firstPanic == nil {
-					firstPanic = re.panic
-				} else {
-					// todo (bs): consider logging the returned value here; perhaps with a
-					// globally configurable logger (that could of course be set to mute
-					// logs)
-				}
-			} else if re.err != nil {
-				if firstErr == nil {
-					firstErr = re.err
-				} else if re.err != ctx.Err() {
-					// todo (bs): if the error is not a context error and a default logger
-					// has been configured, consider using the default logger here. Also:
-					// should this be compared to context.Canceled, or ctx.Err() being
-					// given?
-				}
+		case re := <-r.errChan:
+			r.handleExit(re)
+		case index := <-r.readyChan:
+			r.handleReady(index)
+		case <-groupDone:
+			groupDone = nil
+			r.beginShutdown(r.ctx.Err())
+		case <-r.shutdownTimeout:
+			r.timeoutErr = r.newTimeoutError()
+		}
+	}
+
+	// Members may have signalled readiness just before exiting, so any
+	// leftover signals are still recorded.
+	for drained := false; !drained; {
+		select {
+		case index := <-r.readyChan:
+			r.handleReady(index)
+		default:
+			drained = true
+		}
+	}
+
+	if r.firstPanic != nil {
+		logSuppressedErrs(r.cfg.logger, r.errs)
+		if r.timeoutErr != nil {
+			logError(r.cfg.logger, "brun: suppressed error", "err", r.timeoutErr)
+		}
+		panic(r.firstPanic)
+	}
+	err := r.errs.err()
+	if err == nil {
+		err = r.shutdownErr
+	}
+	if r.timeoutErr != nil {
+		r.timeoutErr.Err = err
+		return r.report, r.timeoutErr
+	}
+	return r.report, err
+}
+
+// startReadyMembers starts every member that hasn't yet been started and has
+// all of its dependencies ready. Nothing is started once shutdown has begun.
+func (r *groupRun) startReadyMembers() {
+	if r.shuttingDown {
+		return
+	}
+	for i := range r.members {
+		if r.started[i] || !r.depsReady(i) {
+			continue
+		}
+		r.start(i)
+	}
+}
+
+// handleReady records the member as ready and starts any members that were
+// waiting on it. Once every member is ready, the group itself is marked as
+// ready, both to its own observers and to any group it's a member of.
+func (r *groupRun) handleReady(index int) {
+	if r.ready[index] {
+		return
+	}
+	r.ready[index] = true
+	r.numReady++
+	r.report.Members[index].Ready = r.clock.Now()
+	if r.readiness != nil {
+		r.readiness.markReady(index)
+	}
+	if r.numReady == len(r.members) {
+		ReadyFunc(r.ctx)()
+	}
+	r.startReadyMembers()
+}
+
+func (r *groupRun) depsReady(index int) bool {
+	for _, dep := range r.deps[index] {
+		if !r.ready[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *groupRun) start(index int) {
+	m := r.members[index]
+	memberCtx := newMemberContext(r.ctx)
+	r.memberCtxs[index] = memberCtx
+	r.started[index] = true
+	r.running++
+	r.report.Members[index].Started = r.clock.Now()
+
+	ctx := context.WithValue(memberCtx, readinessKey{}, &readiness{
+		index:     index,
+		readyChan: r.readyChan,
+	})
+	spawn(ctx, kindGroup, index, m.name, func(ctx context.Context) {
+		if r.cfg.shutdownTimeout > 0 {
+			atomic.StoreInt64(&r.goroutineIDs[index], goroutineID())
+		}
+		r.errChan <- execMember(ctx, r.metrics, index, m.name, m.fn)
+	})
+}
+
+func (r *groupRun) handleExit(re runErr) {
+	r.running--
+	r.exited[re.index] = true
+	exitErr := re.exitErr()
+	optionalExit := r.members[re.index].optional && re.panic == nil
+	r.report.Members[re.index] = MemberReport{
+		Index:     re.index,
+		Name:      re.name,
+		Started:   re.started,
+		Exited:    re.exited,
+		Ready:     r.report.Members[re.index].Ready,
+		Err:       exitErr,
+		Optional:  r.report.Members[re.index].Optional,
+		Initiator: !r.shuttingDown && !optionalExit,
+	}
+
+	// Optional members exiting are only logged, unless it's an error that
+	// arrives as part of shutdown.
+	memberCtx := r.memberCtxs[re.index]
+	if optionalExit {
+		if !r.shuttingDown || (re.err != nil && !isCancelErr(memberCtx, re.err)) {
+			logWarn(r.cfg.logger, "brun: optional member exited",
+				"member", re.index,
+				"name", re.name,
+				"err", re.err)
+		}
+		if r.shuttingDown {
+			r.advanceShutdown()
+		}
+		return
+	}
+
+	// Errors must be recorded against the member's context before any
+	// cancellation, as otherwise a context error from a member that timed out
+	// on its own would be mistaken for a symptom of shutdown. Panics are never
+	// a symptom of shutdown, whatever their value.
+	if re.panic != nil && r.cfg.panicsAsErrors {
+		r.errs.addPanic(exitErr)
+	} else if re.panic != nil {
+		if r.firstPanic == nil {
+			r.firstPanic = re.panic
+		} else {
+			logSuppressedPanic(r.cfg.logger, re)
+		}
+	} else {
+		r.errs.add(memberCtx, re.err)
+	}
+
+	if r.shuttingDown {
+		r.advanceShutdown()
+	} else {
+		r.beginShutdown(context.Canceled)
+	}
+}
+
+// beginShutdown starts cancelling members, with err as the error their
+// contexts will report.
+func (r *groupRun) beginShutdown(err error) {
+	if r.shuttingDown {
+		return
+	}
+	r.shuttingDown = true
+	r.shutdownErr = err
+	if r.cfg.shutdownTimeout > 0 {
+		r.shutdownTimeout, r.stopShutdown = r.clock.NewTimer(r.cfg.shutdownTimeout)
+	}
+	r.advanceShutdown()
+}
+
+// advanceShutdown cancels every running member at the current level. Once
+// they've all exited, it moves down to the next level.
+func (r *groupRun) advanceShutdown() {
+	for ; r.cancelLevel >= 0; r.cancelLevel-- {
+		waiting := false
+		for i, level := range r.levels {
+			if level != r.cancelLevel || !r.started[i] || r.exited[i] {
+				continue
 			}
+			r.memberCtxs[i].cancel(r.shutdownErr)
+			waiting = true
+		}
+		if waiting {
+			return
+		}
+	}
+}
+
+// newTimeoutError describes the members that have yet to exit when the
+// group's shutdown timeout expires. Only members that have been cancelled are
+// stuck; those at lower levels are still waiting their turn.
+func (r *groupRun) newTimeoutError() *ShutdownTimeoutError {
+	var stuck []int
+	var stuckIDs []int64
+	for i, level := range r.levels {
+		if level >= r.cancelLevel && r.started[i] && !r.exited[i] {
+			stuck = append(stuck, i)
+			stuckIDs = append(stuckIDs, atomic.LoadInt64(&r.goroutineIDs[i]))
 		}
 	}
-	if firstPanic != nil {
-		panic(firstPanic)
+	stacks := goroutineStacks(stuckIDs)
+
+	timeoutErr := &ShutdownTimeoutError{
+		Timeout: r.cfg.shutdownTimeout,
 	}
-	// note (bs): is the context is cancelled and the first response from a fn is
-	// a non-context error, it would be returned rather than the fn error. Is that
-	// ok? I think so, but let's think on it a little
-	if firstErr != nil {
-		return firstErr
+	for n, i := range stuck {
+		timeoutErr.Stragglers = append(timeoutErr.Stragglers, Straggler{
+			Member: i,
+			Name:   r.report.Members[i].Name,
+			Stack:  stacks[stuckIDs[n]],
+		})
 	}
-	return ctx.Err()
+	return timeoutErr
 }
@@ -3,9 +3,12 @@ package brun
 import (
 	"context"
 	"errors"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/bennettjames/go-concurrency-experiments/brun/bruntest"
 )
 
 func Test_Group(t *testing.T) {
@@ -149,3 +152,151 @@ func getPanic(ctx context.Context, fn plainFn) interface{} {
 		return nil
 	}
 }
+
+func Test_GroupReport(t *testing.T) {
+	t.Run("identifiesInitiator", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		innerErr := errors.New("this is an error")
+		g := Group{}
+		g.AddNamed("waiter", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		g.AddNamed("failer", func(ctx context.Context) error {
+			time.Sleep(5 * time.Millisecond)
+			return innerErr
+		})
+		report, err := g.RunReport(ctx)
+		if err != innerErr {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		initiator, ok := report.Initiator()
+		if !ok || initiator.Name != "failer" || initiator.Err != innerErr {
+			t.Fatalf("expected failer to be the initiator, got %+v", initiator)
+		}
+		waiter := report.Members[0]
+		if waiter.Name != "waiter" || waiter.Initiator || waiter.Err != context.Canceled {
+			t.Fatalf("unexpected report for waiter: %+v", waiter)
+		}
+		if waiter.Exited.Sub(waiter.Started) < 5*time.Millisecond {
+			t.Fatalf("expected waiter to have run at least 5ms, got %+v", waiter)
+		}
+	})
+
+	t.Run("noInitiatorOnCancel", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		defer cancel()
+
+		g := Group{}
+		g.AddNamed("waiter", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		report, _ := g.RunReport(ctx)
+		if _, ok := report.Initiator(); ok {
+			t.Fatalf("expected no initiator, got:\n%s", report)
+		}
+	})
+
+	t.Run("namedPanic", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		g := Group{PanicsAsErrors: true}
+		g.AddNamed("panicker", func(ctx context.Context) error {
+			panic("oh no")
+		})
+		report, err := g.RunReport(ctx)
+
+		var panicErr *PanicError
+		if !errors.As(err, &panicErr) || panicErr.Name != "panicker" {
+			t.Fatalf("expected named panic error, got %v", err)
+		}
+		if report.Members[0].Err != err {
+			t.Fatalf("expected panic error in report, got %v", report.Members[0].Err)
+		}
+	})
+}
+
+func Test_GroupShutdownTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	innerErr := errors.New("this is an error")
+	g := Group{ShutdownTimeout: 10 * time.Millisecond}
+	g.AddNamed("failer", func(ctx context.Context) error {
+		return innerErr
+	})
+	g.AddNamed("stuck", func(ctx context.Context) error {
+		stuckMember(release)
+		return nil
+	})
+	g.AddNamed("polite", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	start := time.Now()
+	report, err := g.RunReport(ctx)
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("expected run to give up on the stuck member")
+	}
+
+	var timeoutErr *ShutdownTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected shutdown timeout error, got %v", err)
+	}
+	if !errors.Is(err, innerErr) {
+		t.Fatalf("expected timeout to wrap the cause of shutdown, got %v", err)
+	}
+	if len(timeoutErr.Stragglers) != 1 || timeoutErr.Stragglers[0].Name != "stuck" {
+		t.Fatalf("expected only the stuck member, got %v", timeoutErr.Stragglers)
+	}
+	if !strings.Contains(string(timeoutErr.Stragglers[0].Stack), "stuckMember") {
+		t.Fatalf("expected stack of stuck member, got:\n%s", timeoutErr.Stragglers[0].Stack)
+	}
+	if !report.Members[1].Exited.IsZero() || report.Members[2].Exited.IsZero() {
+		t.Fatalf("unexpected report:\n%s", report)
+	}
+}
+
+func Test_GroupShutdownTimeoutClock(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	clock := bruntest.NewFakeClock(time.Unix(0, 0))
+	g := Group{ShutdownTimeout: 1 * time.Minute}
+	g.Add(func(ctx context.Context) error {
+		return errors.New("this is an error")
+	})
+	g.Add(func(ctx context.Context) error {
+		stuckMember(release)
+		return nil
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- g.Run(WithClock(ctx, clock))
+	}()
+
+	clock.BlockUntil(1)
+	clock.Advance(59 * time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("expected run to wait out the timeout, got %v", err)
+	case <-time.After(5 * time.Millisecond):
+	}
+	clock.Advance(1 * time.Second)
+
+	var timeoutErr *ShutdownTimeoutError
+	if err := <-done; !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected shutdown timeout error, got %v", err)
+	}
+}
@@ -2,7 +2,9 @@ package brun
 
 import (
 	"context"
+	"runtime/debug"
 	"sync"
+	"time"
 )
 
 type plainFn func(ctx context.Context)
@@ -11,8 +13,98 @@ type errFn func(ctx context.Context) error
 // runErr is a simple record of a completed execution, and any panics or
 // errors that were returned or raised.
 type runErr struct {
+	index int
+	name  string
 	err   error
 	panic interface{}
+	stack []byte
+
+	started, exited time.Time
+}
+
+// panicError converts the panic in the record to a *PanicError.
+func (re runErr) panicError() *PanicError {
+	return &PanicError{
+		Value:  re.panic,
+		Stack:  re.stack,
+		Member: re.index,
+		Name:   re.name,
+	}
+}
+
+// exitErr returns how the execution ended: the panic as a *PanicError if
+// there was one, and otherwise the returned error.
+func (re runErr) exitErr() error {
+	if re.panic != nil {
+		return re.panicError()
+	}
+	return re.err
+}
+
+// runConfig holds the settings that can vary between runs of a group or batch.
+type runConfig struct {
+	panicsAsErrors  bool
+	logger          Logger
+	shutdownTimeout time.Duration
+	name            string
+	metrics         Metrics
+}
+
+// defaultRunConfig returns the settings used by runs that have no explicit
+// configuration of their own.
+func defaultRunConfig() runConfig {
+	return runConfig{
+		panicsAsErrors: panicsAsErrors(),
+		logger:         getLogger(nil),
+		metrics:        getMetrics(nil),
+	}
+}
+
+// logSuppressedPanic reports a panic that can't be passed back to the caller,
+// as an earlier one is already being propagated.
+func logSuppressedPanic(l Logger, re runErr) {
+	logError(l, "brun: suppressed panic",
+		"member", re.index,
+		"name", re.name,
+		"panic", re.panic,
+		"stack", string(re.stack))
+}
+
+// logSuppressedErrs reports the errors that are being dropped in favor of
+// propagating a panic.
+func logSuppressedErrs(l Logger, errs errSet) {
+	for _, err := range errs.errs {
+		logError(l, "brun: suppressed error", "err", err)
+	}
+}
+
+// execMember runs the member at the given index to completion, recovering any
+// panic it raises along with the stack it was raised from.
+func execMember(
+	ctx context.Context,
+	metrics memberMetrics,
+	index int,
+	name string,
+	fn func(ctx context.Context) error,
+) (re runErr) {
+	re.index = index
+	re.name = name
+	clock := getClock(ctx)
+	re.started = clock.Now()
+	metrics.started()
+	defer func() {
+		if r := recover(); r != nil {
+			re.panic = r
+			re.stack = debug.Stack()
+		}
+		re.exited = clock.Now()
+		metrics.exited(ctx, re)
+	}()
+	if name != "" {
+		ctx = context.WithValue(ctx, memberNameKey{}, name)
+	}
+	re.err = execErrFnInContext(ctx, fn)
+	return re
 }
 
 // execFnInContext runs the given function with a cancel that's executed
@@ -34,20 +126,71 @@ func execErrFnInContext(
 	return fn(ctx)
 }
 
-// fnQueue is a simple threadsafe way to store and retrieve a set of functions.
-type fnQueue struct {
+// waitTimeout waits for wg, giving up after timeout on the given clock if it's
+// positive. Returns false if the wait timed out.
+func waitTimeout(clock Clock, wg *sync.WaitGroup, timeout time.Duration) bool {
+	if timeout <= 0 {
+		wg.Wait()
+		return true
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	timeoutChan, stop := clock.NewTimer(timeout)
+	defer stop()
+	select {
+	case <-done:
+		return true
+	case <-timeoutChan:
+		return false
+	}
+}
+
+// member is a function to be run as part of a group or batch, along with the
+// name it was added under, if any.
+type member struct {
+	name      string
+	fn        func(ctx context.Context) error
+	dependsOn []string
+	optional  bool
+}
+
+// memberQueue is a simple threadsafe way to store and retrieve a set of
+// members.
+type memberQueue struct {
 	l     sync.Mutex
-	queue []func(ctx context.Context) error
+	queue []member
 }
 
-func (q *fnQueue) push(fn func(ctx context.Context) error) {
+func (q *memberQueue) push(m member) {
 	q.l.Lock()
 	defer q.l.Unlock()
-	q.queue = append(q.queue, fn)
+	q.queue = append(q.queue, m)
 }
 
-func (q *fnQueue) get() []func(ctx context.Context) error {
+func (q *memberQueue) get() []member {
 	q.l.Lock()
 	defer q.l.Unlock()
 	return q.queue[:]
 }
+
+// getFns returns just the functions of every member.
+func (q *memberQueue) getFns() []func(ctx context.Context) error {
+	members := q.get()
+	fns := make([]func(ctx context.Context) error, len(members))
+	for i, m := range members {
+		fns[i] = m.fn
+	}
+	return fns
+}
+
+// unnamedMembers converts plain functions to members with no names.
+func unnamedMembers(fns []func(ctx context.Context) error) []member {
+	members := make([]member, len(fns))
+	for i, fn := range fns {
+		members[i] = member{fn: fn}
+	}
+	return members
+}
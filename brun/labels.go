@@ -0,0 +1,72 @@
+package brun
+
+import (
+	"context"
+	"runtime/pprof"
+	"strconv"
+)
+
+// Profiler labels set on the goroutines brun runs work in, so that profiles
+// and goroutine dumps can be attributed to their owner.
+const (
+	// LabelKind is the kind of primitive running the goroutine: one of
+	// "group", "batch", "set", "supervisor" or "retry".
+	LabelKind = "brun.kind"
+	// LabelMember is the member's name, key or index within its primitive.
+	LabelMember = "brun.member"
+	// LabelScope lists the members the goroutine is nested within, outermost
+	// first, as "kind:member" pairs separated by slashes. It's empty for
+	// members of a top-level primitive.
+	LabelScope = "brun.scope"
+)
+
+const (
+	kindGroup      = "group"
+	kindBatch      = "batch"
+	kindSet        = "set"
+	kindSupervisor = "supervisor"
+	kindRetry      = "retry"
+)
+
+type scopeKey struct{}
+
+// spawn runs fn in a new goroutine, labelled as the given member of a
+// primitive of the given kind, and under its own trace task. Every goroutine
+// brun starts to run a member should be started this way.
+func spawn(
+	ctx context.Context,
+	kind string,
+	index int,
+	name string,
+	fn func(ctx context.Context),
+) {
+	member := name
+	if member == "" {
+		member = strconv.Itoa(index)
+	}
+	ctx, labels := withMemberLabels(ctx, kind, member)
+	go pprof.Do(ctx, labels, func(ctx context.Context) {
+		ctx, task := traceMember(ctx, kind, member)
+		defer task.End()
+		fn(ctx)
+	})
+}
+
+// withMemberLabels returns the labels for the given member, along with a
+// context that carries it as the scope of anything nested within it.
+func withMemberLabels(
+	ctx context.Context,
+	kind, member string,
+) (context.Context, pprof.LabelSet) {
+	scope, _ := ctx.Value(scopeKey{}).(string)
+	labels := pprof.Labels(
+		LabelKind, kind,
+		LabelMember, member,
+		LabelScope, scope)
+
+	if scope != "" {
+		scope += "/"
+	}
+	scope += kind + ":" + member
+	return context.WithValue(ctx, scopeKey{}, scope), labels
+}
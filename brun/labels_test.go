@@ -0,0 +1,76 @@
+package brun
+
+import (
+	"context"
+	"runtime/pprof"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Labels(t *testing.T) {
+	// labelsOf returns the brun labels on ctx, as kind, member and scope.
+	labelsOf := func(ctx context.Context) [3]string {
+		var labels [3]string
+		for i, key := range []string{LabelKind, LabelMember, LabelScope} {
+			labels[i], _ = pprof.Label(ctx, key)
+		}
+		return labels
+	}
+
+	t.Run("labelsNestedMembers", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		var l sync.Mutex
+		seen := map[string][3]string{}
+		record := func(at string, ctx context.Context) {
+			l.Lock()
+			defer l.Unlock()
+			seen[at] = labelsOf(ctx)
+		}
+
+		g := Group{}
+		g.AddNamed("api", func(ctx context.Context) error {
+			record("group", ctx)
+			return BatchRun(ctx, func(ctx context.Context) error {
+				record("batch", ctx)
+				return nil
+			})
+		})
+		g.AddNamed("poller", GapRetryErr(10*time.Millisecond, func(ctx context.Context) error {
+			record("retry", ctx)
+			<-ctx.Done()
+			return ctx.Err()
+		}))
+		g.Run(ctx)
+
+		expected := map[string][3]string{
+			"group": {"group", "api", ""},
+			"batch": {"batch", "0", "group:api"},
+			"retry": {"retry", "poller", "group:poller"},
+		}
+		for at, labels := range expected {
+			if seen[at] != labels {
+				t.Fatalf("unexpected labels in %s: %v", at, seen[at])
+			}
+		}
+	})
+
+	t.Run("labelsSetTasks", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		labels := make(chan [3]string, 1)
+		s := NewSet()
+		s.AddKeyed("refresh", func(ctx context.Context) {
+			labels <- labelsOf(ctx)
+			cancel()
+		})
+		s.Run(ctx)
+
+		if got := <-labels; got != [3]string{"set", "refresh", ""} {
+			t.Fatalf("unexpected labels: %v", got)
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package brun
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// Logger receives reports of failures that brun would otherwise have to drop,
+// such as a second panic in a group that is already shutting down. Messages
+// are accompanied by alternating key/value pairs that describe the failure.
+type Logger interface {
+	Log(msg string, keyvals ...interface{})
+}
+
+// LoggerFunc adapts an ordinary function to a Logger.
+type LoggerFunc func(msg string, keyvals ...interface{})
+
+// Log calls f(msg, keyvals...).
+func (f LoggerFunc) Log(msg string, keyvals ...interface{}) {
+	f(msg, keyvals...)
+}
+
+// MuteLogger discards everything it's given. It is the default logger.
+var MuteLogger Logger = LoggerFunc(func(string, ...interface{}) {})
+
+// StdLogger returns a Logger that writes to the given standard library logger,
+// formatting each key/value pair as "key=value". If l is nil, the standard
+// logger from the log package is used.
+func StdLogger(l *log.Logger) Logger {
+	return LoggerFunc(func(msg string, keyvals ...interface{}) {
+		var buf bytes.Buffer
+		buf.WriteString(msg)
+		for i := 0; i < len(keyvals); i += 2 {
+			var val interface{} = "(missing)"
+			if i+1 < len(keyvals) {
+				val = keyvals[i+1]
+			}
+			fmt.Fprintf(&buf, " %v=%v", keyvals[i], val)
+		}
+		if l == nil {
+			log.Print(buf.String())
+		} else {
+			l.Print(buf.String())
+		}
+	})
+}
+
+// loggerHolder wraps a logger so it can be stored in an atomic.Value, which
+// requires every stored value to have the same concrete type.
+type loggerHolder struct {
+	Logger
+}
+
+var defaultLogger atomic.Value
+
+// SetLogger changes the logger used by everything in the package that doesn't
+// have one of its own, including the retry helpers. Passing nil restores the
+// default, which mutes all logs.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = MuteLogger
+	}
+	defaultLogger.Store(loggerHolder{l})
+}
+
+// LevelKey is the key under which brun gives the severity of every message it
+// logs, always as the first pair: LevelError for failures it had to drop, and
+// LevelWarn for failures that were handled, such as a function being retried.
+// Adapters like SlogLogger use it to pick the level to log at.
+const LevelKey = "level"
+
+// The severities given under LevelKey.
+const (
+	LevelError = "error"
+	LevelWarn  = "warn"
+)
+
+// logError reports a failure that brun had to drop.
+func logError(l Logger, msg string, keyvals ...interface{}) {
+	l.Log(msg, append([]interface{}{LevelKey, LevelError}, keyvals...)...)
+}
+
+// logWarn reports a failure that brun handled, but which may still be of
+// interest.
+func logWarn(l Logger, msg string, keyvals ...interface{}) {
+	l.Log(msg, append([]interface{}{LevelKey, LevelWarn}, keyvals...)...)
+}
+
+// getLogger returns l if it's set, and the package-level logger otherwise.
+func getLogger(l Logger) Logger {
+	if l != nil {
+		return l
+	}
+	if h, ok := defaultLogger.Load().(loggerHolder); ok {
+		return h.Logger
+	}
+	return MuteLogger
+}
@@ -0,0 +1,29 @@
+//go:build go1.21
+// +build go1.21
+
+package brun
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogLogger returns a Logger that writes to the given structured logger. The
+// level is taken from the message's LevelKey, which is then dropped, and is
+// error if it has none. If l is nil, slog's default logger is used.
+func SlogLogger(l *slog.Logger) Logger {
+	return LoggerFunc(func(msg string, keyvals ...interface{}) {
+		logger := l
+		if logger == nil {
+			logger = slog.Default()
+		}
+		level := slog.LevelError
+		if len(keyvals) >= 2 && keyvals[0] == LevelKey {
+			if keyvals[1] == LevelWarn {
+				level = slog.LevelWarn
+			}
+			keyvals = keyvals[2:]
+		}
+		logger.Log(context.Background(), level, msg, keyvals...)
+	})
+}
@@ -0,0 +1,35 @@
+//go:build go1.21
+// +build go1.21
+
+package brun
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func Test_SlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := SlogLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	l.Log("a message", "member", 2)
+	out := buf.String()
+	if !strings.Contains(out, "level=ERROR") ||
+		!strings.Contains(out, `msg="a message"`) ||
+		!strings.Contains(out, "member=2") {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func Test_SlogLoggerLevels(t *testing.T) {
+	var buf bytes.Buffer
+	l := SlogLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	logWarn(l, "a warning", "member", 2)
+	out := buf.String()
+	if !strings.Contains(out, "level=WARN") || strings.Count(out, LevelKey+"=") != 1 {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
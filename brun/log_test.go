@@ -0,0 +1,102 @@
+package brun
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_StdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := StdLogger(log.New(&buf, "", 0))
+
+	l.Log("a message", "key", 1, "dangling")
+	if got := buf.String(); got != "a message key=1 dangling=(missing)\n" {
+		t.Fatalf("unexpected output: %q", got)
+	}
+}
+
+func Test_logsSuppressedFailures(t *testing.T) {
+	t.Run("groupPanics", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		logs := &recordingLogger{}
+		g := Group{Logger: logs}
+		started := make(chan struct{})
+		g.Add(func(ctx context.Context) error {
+			close(started)
+			panic("first")
+		})
+		g.Add(func(ctx context.Context) error {
+			<-started
+			<-ctx.Done()
+			panic("second")
+		})
+		getPanic(ctx, func(ctx context.Context) {
+			g.Run(ctx)
+		})
+
+		if msgs := logs.messages(); len(msgs) != 1 || msgs[0] != "brun: suppressed panic" {
+			t.Fatalf("expected suppressed panic to be logged, got %v", msgs)
+		}
+	})
+
+	t.Run("batchErrorsDuringPanic", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		logs := &recordingLogger{}
+		b := Batch{Logger: logs}
+		failed := make(chan struct{})
+		b.Add(func(ctx context.Context) error {
+			defer close(failed)
+			return errors.New("failure")
+		})
+		b.Add(func(ctx context.Context) error {
+			<-failed
+			panic("panic")
+		})
+		getPanic(ctx, func(ctx context.Context) {
+			b.Run(ctx)
+		})
+
+		if msgs := logs.messages(); len(msgs) != 1 || msgs[0] != "brun: suppressed error" {
+			t.Fatalf("expected suppressed error to be logged, got %v", msgs)
+		}
+	})
+
+	t.Run("packageLogger", func(t *testing.T) {
+		logs := &recordingLogger{}
+		SetLogger(logs)
+		defer SetLogger(nil)
+
+		if getLogger(nil) != logs {
+			t.Fatal("expected package logger to be used")
+		}
+		if own := (&recordingLogger{}); getLogger(own) != own {
+			t.Fatal("expected explicit logger to take precedence")
+		}
+	})
+}
+
+type recordingLogger struct {
+	l    sync.Mutex
+	msgs []string
+}
+
+func (r *recordingLogger) Log(msg string, keyvals ...interface{}) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.msgs = append(r.msgs, msg)
+}
+
+func (r *recordingLogger) messages() []string {
+	r.l.Lock()
+	defer r.l.Unlock()
+	return append([]string(nil), r.msgs...)
+}
@@ -0,0 +1,185 @@
+package brun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Exit codes returned by RunMain with the default MainConfig.ExitCode.
+const (
+	// ExitOK means the group exited without error, either because a member
+	// returned nil or because it was shut down cleanly by a signal.
+	ExitOK = 0
+	// ExitError means the group exited with an error.
+	ExitError = 1
+	// ExitPanic means a member of the group panicked.
+	ExitPanic = 2
+	// ExitForced means the process was told to exit before the group finished
+	// shutting down.
+	ExitForced = 3
+)
+
+// ErrForcedExit is passed to MainConfig.ExitCode when a second signal or the
+// grace period expiring forces an exit before the group has shut down.
+var ErrForcedExit = errors.New("brun: forced exit before shutdown completed")
+
+// MainConfig configures Main and RunMain. The zero value is ready to use.
+type MainConfig struct {
+	// Signals cancel the group when received. If empty, SIGINT and SIGTERM are
+	// used.
+	Signals []os.Signal
+
+	// GracePeriod bounds how long the group may take to shut down once
+	// signalled before the process exits anyway. If zero, it waits
+	// indefinitely, although a second signal will still force an exit.
+	GracePeriod time.Duration
+
+	// Output receives a summary of the shutdown. If nil, os.Stderr is used.
+	Output io.Writer
+
+	// ExitCode maps the result of the group to the process's exit code. It is
+	// given nil if the group exited cleanly, a *PanicError if a member
+	// panicked, and ErrForcedExit if the exit was forced. If nil,
+	// DefaultExitCode is used.
+	ExitCode func(err error) int
+}
+
+// Main runs the group as the body of a process, and exits the process once
+// it's done. See RunMain for details.
+func Main(g *Group, cfg MainConfig) {
+	os.Exit(RunMain(context.Background(), g, cfg))
+}
+
+// RunMain runs the group until it exits, ctx is cancelled, or one of the
+// configured signals is received, then returns the process exit code for
+// how it ended. After the first signal the group is cancelled and given the
+// grace period to shut down; a second signal, or the grace period expiring,
+// makes RunMain return without waiting any longer. A summary of the shutdown
+// is written to the configured output.
+//
+// A panic in a member is returned as a *PanicError rather than re-raised,
+// regardless of the group's PanicsAsErrors setting.
+func RunMain(ctx context.Context, g *Group, cfg MainConfig) int {
+	signals := cfg.Signals
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	out := cfg.Output
+	if out == nil {
+		out = os.Stderr
+	}
+	exitCode := cfg.ExitCode
+	if exitCode == nil {
+		exitCode = DefaultExitCode
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, signals...)
+	defer signal.Stop(sigChan)
+
+	type result struct {
+		report *ShutdownReport
+		err    error
+	}
+	done := make(chan result, 1)
+	runCfg := g.config()
+	runCfg.panicsAsErrors = true
+	go func() {
+		report, err := g.runReport(ctx, runCfg)
+		done <- result{report, err}
+	}()
+
+	var signalled os.Signal
+	var graceExpired <-chan time.Time
+	for {
+		select {
+		case res := <-done:
+			err := res.err
+			// Timeouts and panics are failures even when they wrap a context
+			// error.
+			var timeoutErr *ShutdownTimeoutError
+			var panicErr *PanicError
+			if !errors.As(err, &timeoutErr) && !errors.As(err, &panicErr) &&
+				(isCancelErr(ctx, err) || endedByCleanExit(res.report, err)) {
+				err = nil
+			}
+			writeMainSummary(out, res.report, err)
+			return exitCode(err)
+
+		case sig := <-sigChan:
+			if signalled != nil {
+				fmt.Fprintf(out,
+					"brun: received %s again; exiting before shutdown completed\n", sig)
+				return exitCode(ErrForcedExit)
+			}
+			signalled = sig
+			fmt.Fprintf(out, "brun: received %s; shutting down\n", sig)
+			cancel()
+			if cfg.GracePeriod > 0 {
+				var stop func() bool
+				graceExpired, stop = getClock(ctx).NewTimer(cfg.GracePeriod)
+				defer stop()
+			}
+
+		case <-graceExpired:
+			fmt.Fprintf(out,
+				"brun: shutdown did not complete within %s; exiting\n", cfg.GracePeriod)
+			return exitCode(ErrForcedExit)
+		}
+	}
+}
+
+// DefaultExitCode maps the result of a group run to ExitOK, ExitError,
+// ExitPanic or ExitForced.
+func DefaultExitCode(err error) int {
+	var panicErr *PanicError
+	switch {
+	case err == nil:
+		return ExitOK
+	case err == ErrForcedExit:
+		return ExitForced
+	case errors.As(err, &panicErr):
+		return ExitPanic
+	}
+	return ExitError
+}
+
+// endedByCleanExit indicates if a run's error is only the cancellation of the
+// group that followed a member returning nil of its own accord.
+func endedByCleanExit(report *ShutdownReport, err error) bool {
+	if !errors.Is(err, context.Canceled) || report == nil {
+		return false
+	}
+	for _, m := range report.Members {
+		if m.Initiator {
+			return m.Err == nil
+		}
+	}
+	return false
+}
+
+// writeMainSummary writes how each member exited, followed by the result of
+// the run and the stack of any panic.
+func writeMainSummary(out io.Writer, report *ShutdownReport, err error) {
+	if report != nil {
+		io.WriteString(out, report.String())
+	}
+	if err == nil {
+		fmt.Fprintln(out, "brun: exited cleanly")
+		return
+	}
+	fmt.Fprintf(out, "brun: exited with error: %s\n", err)
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) && len(panicErr.Stack) > 0 {
+		out.Write(panicErr.Stack)
+	}
+}
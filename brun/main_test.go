@@ -0,0 +1,225 @@
+package brun
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bennettjames/go-concurrency-experiments/brun/bruntest"
+)
+
+// syncBuffer is a buffer that's safe to write to and read from concurrently.
+type syncBuffer struct {
+	l   sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return b.buf.String()
+}
+
+func Test_RunMain(t *testing.T) {
+	interrupt := func(t *testing.T) {
+		proc, err := os.FindProcess(os.Getpid())
+		if err != nil {
+			t.Errorf("unable to find own process: %v", err)
+			return
+		}
+		if err := proc.Signal(os.Interrupt); err != nil {
+			t.Errorf("unable to send interrupt: %v", err)
+		}
+	}
+
+	t.Run("exitsCleanlyOnSignal", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		out := &syncBuffer{}
+		g := &Group{}
+		g.AddNamed("server", func(ctx context.Context) error {
+			ReadyFunc(ctx)()
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		go func() {
+			<-g.Ready()
+			interrupt(t)
+		}()
+
+		if code := RunMain(ctx, g, MainConfig{Output: out}); code != ExitOK {
+			t.Fatalf("unexpected exit code %d; output:\n%s", code, out)
+		}
+		summary := out.String()
+		if !strings.Contains(summary, "shutting down") ||
+			!strings.Contains(summary, "server: ran") ||
+			!strings.Contains(summary, "brun: exited cleanly") {
+			t.Fatalf("unexpected summary:\n%s", summary)
+		}
+	})
+
+	t.Run("panicOnSignalIsNotClean", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		g := &Group{}
+		g.AddNamed("server", func(ctx context.Context) error {
+			ReadyFunc(ctx)()
+			<-ctx.Done()
+			panic(ctx.Err())
+		})
+		go func() {
+			<-g.Ready()
+			interrupt(t)
+		}()
+
+		out := &syncBuffer{}
+		if code := RunMain(ctx, g, MainConfig{Output: out}); code != ExitPanic {
+			t.Fatalf("unexpected exit code %d; output:\n%s", code, out)
+		}
+	})
+
+	t.Run("forcesExitOnSecondSignal", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		release := make(chan struct{})
+		defer close(release)
+		cancelled := make(chan struct{})
+
+		out := &syncBuffer{}
+		g := &Group{}
+		g.Add(func(ctx context.Context) error {
+			ReadyFunc(ctx)()
+			<-ctx.Done()
+			close(cancelled)
+			stuckMember(release)
+			return nil
+		})
+		go func() {
+			<-g.Ready()
+			interrupt(t)
+			<-cancelled
+			interrupt(t)
+		}()
+
+		if code := RunMain(ctx, g, MainConfig{Output: out}); code != ExitForced {
+			t.Fatalf("unexpected exit code %d; output:\n%s", code, out)
+		}
+		if !strings.Contains(out.String(), "again") {
+			t.Fatalf("unexpected summary:\n%s", out)
+		}
+	})
+
+	t.Run("forcesExitAfterGracePeriod", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		release := make(chan struct{})
+		defer close(release)
+
+		clock := bruntest.NewFakeClock(time.Unix(0, 0))
+		g := &Group{}
+		g.Add(func(ctx context.Context) error {
+			ReadyFunc(ctx)()
+			stuckMember(release)
+			return nil
+		})
+		go func() {
+			<-g.Ready()
+			interrupt(t)
+			clock.BlockUntil(1)
+			clock.Advance(10 * time.Second)
+		}()
+
+		cfg := MainConfig{GracePeriod: 10 * time.Second, Output: &syncBuffer{}}
+		if code := RunMain(WithClock(ctx, clock), g, cfg); code != ExitForced {
+			t.Fatalf("unexpected exit code %d", code)
+		}
+	})
+
+	t.Run("exitsCleanlyWhenMemberReturns", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		out := &syncBuffer{}
+		g := &Group{}
+		g.AddNamed("job", func(ctx context.Context) error {
+			return nil
+		})
+		g.AddNamed("server", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		if code := RunMain(ctx, g, MainConfig{Output: out}); code != ExitOK {
+			t.Fatalf("unexpected exit code %d; output:\n%s", code, out)
+		}
+		if !strings.Contains(out.String(), "brun: exited cleanly") {
+			t.Fatalf("unexpected summary:\n%s", out)
+		}
+
+		g = &Group{}
+		g.AddNamed("job", func(ctx context.Context) error {
+			return nil
+		})
+		g.AddNamed("client", func(ctx context.Context) error {
+			<-ctx.Done()
+			return fmt.Errorf("closing client: %w", ctx.Err())
+		})
+		out = &syncBuffer{}
+		if code := RunMain(ctx, g, MainConfig{Output: out}); code != ExitOK {
+			t.Fatalf("unexpected exit code %d with wrapped cancellation; output:\n%s", code, out)
+		}
+	})
+
+	t.Run("mapsErrorsToExitCodes", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		g := &Group{}
+		g.AddNamed("failer", func(ctx context.Context) error {
+			return errors.New("failure")
+		})
+		out := &syncBuffer{}
+		if code := RunMain(ctx, g, MainConfig{Output: out}); code != ExitError {
+			t.Fatalf("unexpected exit code %d", code)
+		}
+		if !strings.Contains(out.String(), "brun: exited with error: failure") {
+			t.Fatalf("unexpected summary:\n%s", out)
+		}
+
+		g = &Group{}
+		g.AddNamed("panicker", func(ctx context.Context) error {
+			panic("oh no")
+		})
+		out = &syncBuffer{}
+		if code := RunMain(ctx, g, MainConfig{Output: out}); code != ExitPanic {
+			t.Fatalf("unexpected exit code %d", code)
+		}
+		if !strings.Contains(out.String(), "goroutine") {
+			t.Fatalf("expected summary to include stack of panic:\n%s", out)
+		}
+
+		cfg := MainConfig{
+			Output:   &syncBuffer{},
+			ExitCode: func(err error) int { return 42 },
+		}
+		if code := RunMain(ctx, g, cfg); code != 42 {
+			t.Fatalf("unexpected exit code %d", code)
+		}
+	})
+}
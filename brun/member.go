@@ -0,0 +1,249 @@
+package brun
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Member describes a function to be run as part of a group, along with how it
+// relates to the group's other members.
+type Member struct {
+	// Name identifies the member in errors and reports, and is how other members
+	// refer to it as a dependency.
+	Name string
+
+	// Run is the function to execute.
+	Run func(ctx context.Context) error
+
+	// DependsOn lists the names of members that must be ready before this one
+	// is started. On shutdown, this member is cancelled and must exit before
+	// any of the members it depends on are cancelled. A member that others
+	// depend on must signal readiness with ReadyFunc, or its dependents will
+	// never be started.
+	DependsOn []string
+
+	// Optional marks the member as non-critical: when it returns, with or
+	// without an error, the exit is logged and recorded in the group's report,
+	// but the other members carry on and the error is not returned from Run.
+	// The group still cancels it and waits for it on shutdown. A panic in an
+	// optional member is handled like any other. If an optional member exits
+	// before signalling readiness, the group and any members that depend on
+	// it never become ready.
+	Optional bool
+}
+
+// ReadyFunc returns a function that marks the group member running under ctx
+// as ready, allowing any members that depend on it to start and counting
+// towards the group's own readiness (see Group.Ready). Calling it more than
+// once has no effect. If ctx doesn't belong to a group member, the returned
+// function does nothing.
+func ReadyFunc(ctx context.Context) func() {
+	r, ok := ctx.Value(readinessKey{}).(*readiness)
+	if !ok {
+		return func() {}
+	}
+	return r.markReady
+}
+
+type readinessKey struct{}
+
+// readiness lets a member of a group run report that it's ready.
+type readiness struct {
+	index     int
+	once      sync.Once
+	readyChan chan<- int
+}
+
+func (r *readiness) markReady() {
+	r.once.Do(func() {
+		r.readyChan <- r.index
+	})
+}
+
+// groupReadiness tracks which members of a group run have signalled that
+// they're ready.
+type groupReadiness struct {
+	l       sync.Mutex
+	claimed bool
+	done    chan struct{}
+	pending map[int]string
+}
+
+func newGroupReadiness() *groupReadiness {
+	return &groupReadiness{
+		done: make(chan struct{}),
+	}
+}
+
+// claim assigns the readiness to a run of the given members. Returns false if
+// it has already been claimed by an earlier run.
+func (r *groupReadiness) claim(members []member) bool {
+	r.l.Lock()
+	defer r.l.Unlock()
+	if r.claimed {
+		return false
+	}
+	r.claimed = true
+	r.pending = make(map[int]string, len(members))
+	for i, m := range members {
+		r.pending[i] = memberLabel(i, m.name)
+	}
+	if len(r.pending) == 0 {
+		close(r.done)
+	}
+	return true
+}
+
+// markReady records the member as ready, and closes the done channel if it
+// was the last one. Returns true if every member is now ready.
+func (r *groupReadiness) markReady(index int) bool {
+	r.l.Lock()
+	defer r.l.Unlock()
+	if _, ok := r.pending[index]; !ok {
+		return false
+	}
+	delete(r.pending, index)
+	if len(r.pending) > 0 {
+		return false
+	}
+	close(r.done)
+	return true
+}
+
+// notReady lists the members that have yet to signal, in the order they were
+// added.
+func (r *groupReadiness) notReady() []string {
+	r.l.Lock()
+	defer r.l.Unlock()
+	indexes := make([]int, 0, len(r.pending))
+	for i := range r.pending {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	labels := make([]string, len(indexes))
+	for n, i := range indexes {
+		labels[n] = r.pending[i]
+	}
+	return labels
+}
+
+// memberLabel identifies a member by its name, or by its index if unnamed.
+func memberLabel(index int, name string) string {
+	if name != "" {
+		return name
+	}
+	return "member " + strconv.Itoa(index)
+}
+
+// memberContext is the context a group member runs under. It carries the
+// values and deadline of the group's context, but is only ever cancelled by
+// the group itself, so that members can be shut down in order. When it is
+// cancelled because the group's context was, it reports the same error.
+type memberContext struct {
+	context.Context
+	done chan struct{}
+
+	l   sync.Mutex
+	err error
+}
+
+func newMemberContext(parent context.Context) *memberContext {
+	return &memberContext{
+		Context: parent,
+		done:    make(chan struct{}),
+	}
+}
+
+func (c *memberContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *memberContext) Err() error {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.err
+}
+
+// cancel marks the context as done with the given error. Only the first call
+// has any effect.
+func (c *memberContext) cancel(err error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+}
+
+// planMembers resolves the dependencies between members to indexes, and
+// assigns each a level: members with no dependencies are at level zero, and
+// every other member is one level above its highest dependency. Returns an
+// error if a dependency doesn't exist or is ambiguous, or if there's a cycle.
+func planMembers(members []member) (deps [][]int, levels []int, err error) {
+	byName := make(map[string]int, len(members))
+	duplicates := map[string]bool{}
+	for i, m := range members {
+		if m.name == "" {
+			continue
+		}
+		if _, ok := byName[m.name]; ok {
+			duplicates[m.name] = true
+		}
+		byName[m.name] = i
+	}
+
+	deps = make([][]int, len(members))
+	for i, m := range members {
+		for _, name := range m.dependsOn {
+			j, ok := byName[name]
+			if !ok {
+				return nil, nil, fmt.Errorf(
+					"brun: member %q depends on unknown member %q", m.name, name)
+			}
+			if duplicates[name] {
+				return nil, nil, fmt.Errorf(
+					"brun: member %q depends on ambiguous name %q", m.name, name)
+			}
+			deps[i] = append(deps[i], j)
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(members))
+	levels = make([]int, len(members))
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visiting:
+			return fmt.Errorf(
+				"brun: dependency cycle involving member %q", members[i].name)
+		case visited:
+			return nil
+		}
+		state[i] = visiting
+		for _, j := range deps[i] {
+			if err := visit(j); err != nil {
+				return err
+			}
+			if levels[j]+1 > levels[i] {
+				levels[i] = levels[j] + 1
+			}
+		}
+		state[i] = visited
+		return nil
+	}
+	for i := range members {
+		if err := visit(i); err != nil {
+			return nil, nil, err
+		}
+	}
+	return deps, levels, nil
+}
@@ -0,0 +1,364 @@
+package brun
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_GroupDependencies(t *testing.T) {
+	t.Run("ordersStartupAndShutdown", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		var l sync.Mutex
+		var events []string
+		record := func(event string) {
+			l.Lock()
+			defer l.Unlock()
+			events = append(events, event)
+		}
+		service := func(name string) func(ctx context.Context) error {
+			return func(ctx context.Context) error {
+				record("start " + name)
+				time.Sleep(2 * time.Millisecond)
+				ReadyFunc(ctx)()
+				<-ctx.Done()
+				time.Sleep(2 * time.Millisecond)
+				record("stop " + name)
+				return ctx.Err()
+			}
+		}
+
+		g := Group{}
+		g.AddMember(Member{
+			Name:      "http",
+			Run:       service("http"),
+			DependsOn: []string{"db", "cache"},
+		})
+		g.AddMember(Member{
+			Name:      "cache",
+			Run:       service("cache"),
+			DependsOn: []string{"db"},
+		})
+		g.AddMember(Member{
+			Name: "db",
+			Run:  service("db"),
+		})
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			cancel()
+		}()
+
+		if err := g.Run(ctx); err != context.Canceled {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []string{
+			"start db", "start cache", "start http",
+			"stop http", "stop cache", "stop db",
+		}
+		if strings.Join(events, ", ") != strings.Join(expected, ", ") {
+			t.Fatalf("unexpected order of events: %v", events)
+		}
+	})
+
+	t.Run("dependentsNeverStartAfterShutdown", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		g := Group{}
+		g.AddMember(Member{
+			Name: "db",
+			Run: func(ctx context.Context) error {
+				return nil
+			},
+		})
+		g.AddMember(Member{
+			Name:      "http",
+			DependsOn: []string{"db"},
+			Run: func(ctx context.Context) error {
+				t.Error("http should never have started")
+				return nil
+			},
+		})
+		report, err := g.RunReport(ctx)
+		if err != context.Canceled {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !report.Members[1].Started.IsZero() {
+			t.Fatalf("expected http to not be started:\n%s", report)
+		}
+	})
+
+	t.Run("timeoutReportsOnlyCancelledMembers", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		release := make(chan struct{})
+		defer close(release)
+		httpStarted := make(chan struct{})
+
+		g := Group{ShutdownTimeout: 10 * time.Millisecond}
+		g.AddMember(Member{
+			Name: "db",
+			Run: func(ctx context.Context) error {
+				ReadyFunc(ctx)()
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		})
+		g.AddMember(Member{
+			Name:      "http",
+			DependsOn: []string{"db"},
+			Run: func(ctx context.Context) error {
+				close(httpStarted)
+				stuckMember(release)
+				return nil
+			},
+		})
+		g.AddNamed("failer", func(ctx context.Context) error {
+			<-httpStarted
+			return errors.New("failure")
+		})
+
+		err := g.Run(ctx)
+		var timeoutErr *ShutdownTimeoutError
+		if !errors.As(err, &timeoutErr) {
+			t.Fatalf("expected shutdown timeout error, got %v", err)
+		}
+		if len(timeoutErr.Stragglers) != 1 || timeoutErr.Stragglers[0].Name != "http" {
+			t.Fatalf("expected only http to be reported, got %v", timeoutErr.Stragglers)
+		}
+	})
+
+	t.Run("invalidDependencies", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		noop := func(ctx context.Context) error { return nil }
+
+		g := Group{}
+		g.AddMember(Member{Name: "a", Run: noop, DependsOn: []string{"missing"}})
+		if err := g.Run(ctx); err == nil || !strings.Contains(err.Error(), "unknown") {
+			t.Fatalf("expected unknown dependency error, got %v", err)
+		}
+
+		g = Group{}
+		g.AddMember(Member{Name: "a", Run: noop, DependsOn: []string{"b"}})
+		g.AddMember(Member{Name: "b", Run: noop, DependsOn: []string{"a"}})
+		if err := g.Run(ctx); err == nil || !strings.Contains(err.Error(), "cycle") {
+			t.Fatalf("expected cycle error, got %v", err)
+		}
+	})
+
+	t.Run("readyFuncOutsideGroup", func(t *testing.T) {
+		ReadyFunc(context.Background())()
+	})
+}
+
+func Test_GroupReadiness(t *testing.T) {
+	t.Run("readyOnceAllSignal", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		g := &Group{}
+		proceed := make(chan struct{})
+		g.AddNamed("fast", func(ctx context.Context) error {
+			ReadyFunc(ctx)()
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		g.AddNamed("slow", func(ctx context.Context) error {
+			<-proceed
+			ReadyFunc(ctx)()
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		ready := g.Ready()
+		if notReady := g.NotReady(); len(notReady) != 2 {
+			t.Fatalf("expected every member to be listed before run, got %v", notReady)
+		}
+
+		runErr := make(chan error, 1)
+		go func() {
+			runErr <- g.Run(ctx)
+		}()
+
+		for len(g.NotReady()) != 1 {
+			time.Sleep(time.Millisecond)
+		}
+		if notReady := g.NotReady(); notReady[0] != "slow" {
+			t.Fatalf("expected slow to not be ready, got %v", notReady)
+		}
+		select {
+		case <-ready:
+			t.Fatal("group should not be ready yet")
+		default:
+		}
+
+		close(proceed)
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			t.Fatal("expected group to become ready")
+		}
+		cancel()
+		<-runErr
+	})
+
+	t.Run("nestedGroupMarksMemberReady", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		outer := &Group{}
+		outer.AddNamed("inner", GroupRunner(
+			func(ctx context.Context) error {
+				ReadyFunc(ctx)()
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		))
+		go outer.Run(ctx)
+
+		select {
+		case <-outer.Ready():
+		case <-ctx.Done():
+			t.Fatal("expected inner group to mark itself ready")
+		}
+	})
+
+	t.Run("reportsReadyTime", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		g := &Group{}
+		g.AddNamed("ready", func(ctx context.Context) error {
+			ReadyFunc(ctx)()
+			return nil
+		})
+		g.AddNamed("never", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		report, _ := g.RunReport(ctx)
+
+		if report.Members[0].Ready.IsZero() || !report.Members[1].Ready.IsZero() {
+			t.Fatalf("unexpected readiness in report: %+v", report.Members)
+		}
+		if notReady := g.NotReady(); len(notReady) != 1 || notReady[0] != "never" {
+			t.Fatalf("expected never to not be ready, got %v", notReady)
+		}
+	})
+}
+
+func Test_GroupOptionalMembers(t *testing.T) {
+	t.Run("exitDoesNotEndGroup", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		logs := &recordingLogger{}
+		g := Group{Logger: logs}
+		g.AddMember(Member{
+			Name:     "flusher",
+			Optional: true,
+			Run: func(ctx context.Context) error {
+				return errors.New("failure")
+			},
+		})
+		g.AddNamed("server", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			cancel()
+		}()
+
+		report, err := g.RunReport(ctx)
+		if err != context.Canceled {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		flusher := report.Members[0]
+		if !flusher.Optional || flusher.Err == nil || flusher.Initiator {
+			t.Fatalf("unexpected report for optional member: %+v", flusher)
+		}
+		if _, ok := report.Initiator(); ok {
+			t.Fatalf("expected no member to have initiated shutdown")
+		}
+		if msgs := logs.messages(); len(msgs) != 1 || msgs[0] != "brun: optional member exited" {
+			t.Fatalf("expected optional member exit to be logged, got %v", msgs)
+		}
+	})
+
+	t.Run("waitedOnDuringShutdown", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		var exited int32
+		g := Group{}
+		g.AddMember(Member{
+			Name:     "warmer",
+			Optional: true,
+			Run: func(ctx context.Context) error {
+				<-ctx.Done()
+				time.Sleep(5 * time.Millisecond)
+				atomic.StoreInt32(&exited, 1)
+				return ctx.Err()
+			},
+		})
+		g.AddNamed("server", func(ctx context.Context) error {
+			return errors.New("failure")
+		})
+
+		err := g.Run(ctx)
+		if err == nil || err.Error() != "failure" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if atomic.LoadInt32(&exited) != 1 {
+			t.Fatalf("expected group to wait for optional member")
+		}
+	})
+
+	t.Run("runsUntilCancelledOnceAllExit", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		g := Group{Logger: MuteLogger}
+		g.AddMember(Member{
+			Optional: true,
+			Run: func(ctx context.Context) error {
+				return nil
+			},
+		})
+
+		if err := g.Run(ctx); err != context.DeadlineExceeded {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("panicsAreFatal", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		g := Group{PanicsAsErrors: true}
+		g.AddMember(Member{
+			Optional: true,
+			Run: func(ctx context.Context) error {
+				panic("oh no")
+			},
+		})
+		g.Add(func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		var panicErr *PanicError
+		if err := g.Run(ctx); !errors.As(err, &panicErr) {
+			t.Fatalf("expected a panic error; got %v", err)
+		}
+	})
+}
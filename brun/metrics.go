@@ -0,0 +1,122 @@
+package brun
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Metrics receives measurements of the members run by brun's primitives, such
+// as how often they start, fail and restart. Implementations must be safe for
+// concurrent use. MetricsRegistry is a ready-made implementation that can be
+// exported through expvar or in the Prometheus text format.
+type Metrics interface {
+	// AddCounter adds delta to the named counter.
+	AddCounter(name string, key MetricKey, delta float64)
+	// AddGauge adds delta, which may be negative, to the named gauge.
+	AddGauge(name string, key MetricKey, delta float64)
+	// Observe records a value in the named histogram.
+	Observe(name string, key MetricKey, value float64)
+}
+
+// MetricKey identifies the primitive a measurement was taken from.
+type MetricKey struct {
+	// Kind is the kind of primitive: "group", "batch", "set", "supervisor" or
+	// "retry".
+	Kind string
+	// Name is the Name of the primitive, or for retries, the name of the member
+	// being retried. It may be empty.
+	Name string
+}
+
+// The measurements brun makes. Members are the members of a group, jobs of a
+// batch, tasks of a set or children of a supervisor.
+const (
+	// MetricStarts counts members started.
+	MetricStarts = "brun_starts_total"
+	// MetricExits counts members that exited, however they did.
+	MetricExits = "brun_exits_total"
+	// MetricErrors counts members that returned an error other than a
+	// cancellation.
+	MetricErrors = "brun_errors_total"
+	// MetricPanics counts members that panicked.
+	MetricPanics = "brun_panics_total"
+	// MetricRestarts counts supervisor children being restarted, and retry
+	// helpers waiting to make another attempt.
+	MetricRestarts = "brun_restarts_total"
+	// MetricRunning gauges the members currently running.
+	MetricRunning = "brun_running"
+	// MetricRunDuration is a histogram of how long members ran, in seconds.
+	MetricRunDuration = "brun_run_duration_seconds"
+)
+
+// metricsHolder wraps a Metrics so it can be stored in an atomic.Value, which
+// requires every stored value to have the same concrete type.
+type metricsHolder struct {
+	Metrics
+}
+
+var defaultMetrics atomic.Value
+
+// SetMetrics changes the metrics sink used by everything in the package that
+// doesn't have one of its own, including the retry helpers. Passing nil
+// restores the default, which records nothing.
+func SetMetrics(m Metrics) {
+	defaultMetrics.Store(metricsHolder{m})
+}
+
+// getMetrics returns m if it's set, and the package-level sink otherwise. It
+// returns nil if there's neither.
+func getMetrics(m Metrics) Metrics {
+	if m != nil {
+		return m
+	}
+	if h, ok := defaultMetrics.Load().(metricsHolder); ok {
+		return h.Metrics
+	}
+	return nil
+}
+
+// memberMetrics records the measurements of the members of a single
+// primitive. The zero value records nothing.
+type memberMetrics struct {
+	sink Metrics
+	key  MetricKey
+}
+
+func newMemberMetrics(sink Metrics, kind, name string) memberMetrics {
+	return memberMetrics{
+		sink: sink,
+		key:  MetricKey{Kind: kind, Name: name},
+	}
+}
+
+func (m memberMetrics) started() {
+	if m.sink == nil {
+		return
+	}
+	m.sink.AddCounter(MetricStarts, m.key, 1)
+	m.sink.AddGauge(MetricRunning, m.key, 1)
+}
+
+// exited records how the member ended. The given context should be the one
+// the member ran under, as with errSet.add.
+func (m memberMetrics) exited(ctx context.Context, re runErr) {
+	if m.sink == nil {
+		return
+	}
+	m.sink.AddCounter(MetricExits, m.key, 1)
+	m.sink.AddGauge(MetricRunning, m.key, -1)
+	m.sink.Observe(MetricRunDuration, m.key, re.exited.Sub(re.started).Seconds())
+	if re.panic != nil {
+		m.sink.AddCounter(MetricPanics, m.key, 1)
+	} else if re.err != nil && !isCancelErr(ctx, re.err) {
+		m.sink.AddCounter(MetricErrors, m.key, 1)
+	}
+}
+
+func (m memberMetrics) restarted() {
+	if m.sink == nil {
+		return
+	}
+	m.sink.AddCounter(MetricRestarts, m.key, 1)
+}
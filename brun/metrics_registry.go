@@ -0,0 +1,270 @@
+package brun
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram buckets used by a MetricsRegistry that
+// doesn't set its own. They suit run durations measured in seconds.
+var DefaultBuckets = []float64{
+	.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300,
+}
+
+// MetricsRegistry is a Metrics implementation that keeps every measurement in
+// memory, to be exported on demand. It can be published with expvar, as it
+// implements expvar.Var:
+//
+//	expvar.Publish("brun", registry)
+//
+// and written in the Prometheus text format, for example to serve it over
+// HTTP:
+//
+//	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+//		registry.WritePrometheus(w)
+//	})
+//
+// Each metric name keeps the type it was first measured as; measurements of
+// another type under the same name are dropped. The zero value is ready to
+// use.
+type MetricsRegistry struct {
+	// Buckets are the upper bounds of histogram buckets, in ascending order.
+	// They must be set before anything is observed. If nil, DefaultBuckets is
+	// used.
+	Buckets []float64
+
+	l      sync.Mutex
+	series map[seriesKey]*series
+	types  map[string]metricType
+}
+
+type metricType int
+
+const (
+	counterType metricType = iota
+	gaugeType
+	histogramType
+)
+
+func (t metricType) String() string {
+	switch t {
+	case gaugeType:
+		return "gauge"
+	case histogramType:
+		return "histogram"
+	}
+	return "counter"
+}
+
+type seriesKey struct {
+	name string
+	key  MetricKey
+}
+
+// series holds the current value of a single metric for a single primitive.
+type series struct {
+	typ metricType
+	// value is the value of a counter or gauge, or the sum of a histogram.
+	value float64
+	// counts holds the number of observations in each bucket of a histogram,
+	// with a final bucket for those above every bound.
+	counts []uint64
+	count  uint64
+}
+
+// AddCounter adds delta to the named counter.
+func (r *MetricsRegistry) AddCounter(name string, key MetricKey, delta float64) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	if s := r.get(name, key, counterType); s != nil {
+		s.value += delta
+	}
+}
+
+// AddGauge adds delta to the named gauge.
+func (r *MetricsRegistry) AddGauge(name string, key MetricKey, delta float64) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	if s := r.get(name, key, gaugeType); s != nil {
+		s.value += delta
+	}
+}
+
+// Observe records a value in the named histogram.
+func (r *MetricsRegistry) Observe(name string, key MetricKey, value float64) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	s := r.get(name, key, histogramType)
+	if s == nil {
+		return
+	}
+	s.value += value
+	s.count++
+	s.counts[sort.SearchFloat64s(r.buckets(), value)]++
+}
+
+// Value returns the current value of a counter or gauge, or the number of
+// observations in a histogram. It returns zero for anything not yet measured.
+func (r *MetricsRegistry) Value(name string, key MetricKey) float64 {
+	r.l.Lock()
+	defer r.l.Unlock()
+	s, ok := r.series[seriesKey{name, key}]
+	if !ok {
+		return 0
+	}
+	if s.typ == histogramType {
+		return float64(s.count)
+	}
+	return s.value
+}
+
+// get returns the series for the given metric, creating it if needed. Returns
+// nil if the name is already in use by a metric of another type. Must be
+// called with the lock held.
+func (r *MetricsRegistry) get(name string, key MetricKey, typ metricType) *series {
+	if r.series == nil {
+		r.series = map[seriesKey]*series{}
+		r.types = map[string]metricType{}
+	}
+	if existing, ok := r.types[name]; !ok {
+		r.types[name] = typ
+	} else if existing != typ {
+		return nil
+	}
+	sk := seriesKey{name, key}
+	s, ok := r.series[sk]
+	if !ok {
+		s = &series{typ: typ}
+		if typ == histogramType {
+			s.counts = make([]uint64, len(r.buckets())+1)
+		}
+		r.series[sk] = s
+	}
+	return s
+}
+
+func (r *MetricsRegistry) buckets() []float64 {
+	if r.Buckets != nil {
+		return r.Buckets
+	}
+	return DefaultBuckets
+}
+
+// sortedKeys returns the key of every series, ordered by metric name and then
+// primitive. Must be called with the lock held.
+func (r *MetricsRegistry) sortedKeys() []seriesKey {
+	keys := make([]seriesKey, 0, len(r.series))
+	for sk := range r.series {
+		keys = append(keys, sk)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		if a.key.Kind != b.key.Kind {
+			return a.key.Kind < b.key.Kind
+		}
+		return a.key.Name < b.key.Name
+	})
+	return keys
+}
+
+// WritePrometheus writes every metric in the Prometheus text exposition
+// format, labelled with the kind and name of the primitive it came from.
+func (r *MetricsRegistry) WritePrometheus(w io.Writer) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	bw := bufio.NewWriter(w)
+	lastName := ""
+	for _, sk := range r.sortedKeys() {
+		s := r.series[sk]
+		if sk.name != lastName {
+			fmt.Fprintf(bw, "# TYPE %s %s\n", sk.name, s.typ)
+			lastName = sk.name
+		}
+		labels := fmt.Sprintf("kind=%s,name=%s",
+			promQuote(sk.key.Kind), promQuote(sk.key.Name))
+		if s.typ != histogramType {
+			fmt.Fprintf(bw, "%s{%s} %s\n", sk.name, labels, promFloat(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range r.buckets() {
+			cumulative += s.counts[i]
+			fmt.Fprintf(bw, "%s_bucket{%s,le=%s} %d\n",
+				sk.name, labels, promQuote(promFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(bw, "%s_bucket{%s,le=\"+Inf\"} %d\n", sk.name, labels, s.count)
+		fmt.Fprintf(bw, "%s_sum{%s} %s\n", sk.name, labels, promFloat(s.value))
+		fmt.Fprintf(bw, "%s_count{%s} %d\n", sk.name, labels, s.count)
+	}
+	return bw.Flush()
+}
+
+// String formats every metric as a JSON object, keyed by metric name and then
+// by primitive as "kind:name". Histograms are objects holding their count,
+// sum and the count in each bucket. This implements expvar.Var.
+func (r *MetricsRegistry) String() string {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	out := map[string]map[string]interface{}{}
+	for _, sk := range r.sortedKeys() {
+		s := r.series[sk]
+		if out[sk.name] == nil {
+			out[sk.name] = map[string]interface{}{}
+		}
+		primitive := sk.key.Kind + ":" + sk.key.Name
+		if s.typ != histogramType {
+			out[sk.name][primitive] = s.value
+			continue
+		}
+		buckets := map[string]uint64{}
+		var cumulative uint64
+		for i, bound := range r.buckets() {
+			cumulative += s.counts[i]
+			buckets[promFloat(bound)] = cumulative
+		}
+		out[sk.name][primitive] = map[string]interface{}{
+			"count":   s.count,
+			"sum":     s.value,
+			"buckets": buckets,
+		}
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// promQuote quotes a label value, escaping it as the Prometheus text format
+// requires.
+func promQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
+
+// promFloat formats a value as the Prometheus text format expects.
+func promFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,169 @@
+package brun
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Metrics(t *testing.T) {
+	t.Run("recordsGroupMembers", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		metrics := &MetricsRegistry{}
+		g := Group{Name: "api", Metrics: metrics, PanicsAsErrors: true}
+		g.Add(func(ctx context.Context) error {
+			return errors.New("failure")
+		})
+		g.Add(func(ctx context.Context) error {
+			<-ctx.Done()
+			panic("oh no")
+		})
+		g.Add(func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		g.Run(ctx)
+
+		key := MetricKey{Kind: "group", Name: "api"}
+		expected := map[string]float64{
+			MetricStarts:      3,
+			MetricExits:       3,
+			MetricErrors:      1,
+			MetricPanics:      1,
+			MetricRunning:     0,
+			MetricRunDuration: 3,
+		}
+		for name, value := range expected {
+			if got := metrics.Value(name, key); got != value {
+				t.Fatalf("unexpected value for %s: %v", name, got)
+			}
+		}
+	})
+
+	t.Run("recordsRestarts", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		metrics := &MetricsRegistry{}
+		attempts := 0
+		s := Supervisor{Name: "workers", Metrics: metrics, Logger: MuteLogger}
+		s.Add(ChildSpec{
+			Name: "poller",
+			Run: (&Retrier{
+				Policy:  MaxAttempts(3, ConstantBackoff(0)),
+				Metrics: metrics,
+			}).Wrap(func(ctx context.Context) error {
+				attempts++
+				if attempts > 3 {
+					<-ctx.Done()
+					return ctx.Err()
+				}
+				return errors.New("failure")
+			}),
+		})
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			cancel()
+		}()
+		s.Run(ctx)
+
+		if got := metrics.Value(MetricRestarts, MetricKey{Kind: "retry", Name: "poller"}); got != 2 {
+			t.Fatalf("unexpected retries: %v", got)
+		}
+		key := MetricKey{Kind: "supervisor", Name: "workers"}
+		if got := metrics.Value(MetricRestarts, key); got != 1 {
+			t.Fatalf("unexpected restarts: %v", got)
+		}
+		if got := metrics.Value(MetricErrors, key); got != 1 {
+			t.Fatalf("unexpected errors: %v", got)
+		}
+	})
+
+	t.Run("usesPackageSink", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		metrics := &MetricsRegistry{}
+		SetMetrics(metrics)
+		defer SetMetrics(nil)
+
+		BatchRun(ctx, func(ctx context.Context) error {
+			return nil
+		}, func(ctx context.Context) error {
+			return nil
+		})
+		if got := metrics.Value(MetricStarts, MetricKey{Kind: "batch"}); got != 2 {
+			t.Fatalf("unexpected starts: %v", got)
+		}
+	})
+}
+
+func Test_MetricsRegistry(t *testing.T) {
+	r := &MetricsRegistry{Buckets: []float64{0.1, 1}}
+	key := MetricKey{Kind: "group", Name: `a "quoted" name`}
+	r.AddCounter(MetricStarts, key, 2)
+	r.AddGauge(MetricRunning, key, 1)
+	r.Observe(MetricRunDuration, key, 0.05)
+	r.Observe(MetricRunDuration, key, 0.5)
+	r.Observe(MetricRunDuration, key, 5)
+
+	t.Run("writesPrometheus", func(t *testing.T) {
+		var buf strings.Builder
+		if err := r.WritePrometheus(&buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		labels := `kind="group",name="a \"quoted\" name"`
+		expected := strings.Join([]string{
+			"# TYPE brun_run_duration_seconds histogram",
+			`brun_run_duration_seconds_bucket{` + labels + `,le="0.1"} 1`,
+			`brun_run_duration_seconds_bucket{` + labels + `,le="1"} 2`,
+			`brun_run_duration_seconds_bucket{` + labels + `,le="+Inf"} 3`,
+			`brun_run_duration_seconds_sum{` + labels + `} 5.55`,
+			`brun_run_duration_seconds_count{` + labels + `} 3`,
+			"# TYPE brun_running gauge",
+			`brun_running{` + labels + `} 1`,
+			"# TYPE brun_starts_total counter",
+			`brun_starts_total{` + labels + `} 2`,
+			"",
+		}, "\n")
+		if buf.String() != expected {
+			t.Fatalf("unexpected output:\n%s", buf.String())
+		}
+	})
+
+	t.Run("formatsExpvar", func(t *testing.T) {
+		var out map[string]map[string]interface{}
+		if err := json.Unmarshal([]byte(r.String()), &out); err != nil {
+			t.Fatalf("expected valid JSON: %v", err)
+		}
+		if out[MetricStarts][`group:a "quoted" name`] != 2.0 {
+			t.Fatalf("unexpected output: %s", r.String())
+		}
+	})
+
+	t.Run("dropsMismatchedTypes", func(t *testing.T) {
+		r := &MetricsRegistry{}
+		other := MetricKey{Kind: "set"}
+		r.AddCounter(MetricStarts, key, 1)
+		r.Observe(MetricStarts, key, 1)
+		r.Observe(MetricStarts, other, 1)
+		r.AddGauge(MetricStarts, key, 1)
+		r.Observe(MetricRunDuration, key, 1)
+		r.AddCounter(MetricRunDuration, key, 1)
+
+		if got := r.Value(MetricStarts, key); got != 1 {
+			t.Fatalf("unexpected counter value: %v", got)
+		}
+		if got := r.Value(MetricStarts, other); got != 0 {
+			t.Fatalf("expected mismatched series to be dropped; got %v", got)
+		}
+		if got := r.Value(MetricRunDuration, key); got != 1 {
+			t.Fatalf("unexpected histogram count: %v", got)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package brun
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// PanicError is returned by a run in place of re-panicking when one of its
+// members panics and panics are configured to be returned as errors.
+type PanicError struct {
+	// Value is the value that was recovered from the panic.
+	Value interface{}
+	// Stack is the stack trace of the panicking goroutine, captured at the
+	// point the panic was recovered.
+	Stack []byte
+	// Member is the index of the member that panicked, in the order it was
+	// added.
+	Member int
+	// Name is the name of the member that panicked, if it has one.
+	Name string
+}
+
+func (e *PanicError) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("brun: panic in member %q: %v", e.Name, e.Value)
+	}
+	return fmt.Sprintf("brun: panic in member %d: %v", e.Member, e.Value)
+}
+
+// Unwrap returns the recovered value if it was itself an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// defaultPanicsAsErrors is the package-level setting for panic handling. It is
+// accessed atomically; any non-zero value means panics are returned as errors.
+var defaultPanicsAsErrors int32
+
+// SetPanicsAsErrors changes how every Group and Batch, as well as the
+// functional helpers like GroupRun, handle member panics. By default a panic
+// is re-raised on the goroutine that called Run; when enabled it is instead
+// returned as a *PanicError. Groups and batches can also opt in individually.
+func SetPanicsAsErrors(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&defaultPanicsAsErrors, v)
+}
+
+func panicsAsErrors() bool {
+	return atomic.LoadInt32(&defaultPanicsAsErrors) != 0
+}
@@ -0,0 +1,110 @@
+package brun
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_PanicError(t *testing.T) {
+	t.Run("groupReturnsPanic", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		g := Group{PanicsAsErrors: true}
+		g.Add(func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		g.Add(func(ctx context.Context) error {
+			panicInMember()
+			return nil
+		})
+		err := g.Run(ctx)
+
+		var panicErr *PanicError
+		if !errors.As(err, &panicErr) {
+			t.Fatalf("expected panic error, got %v", err)
+		}
+		if panicErr.Value != "member panic" || panicErr.Member != 1 {
+			t.Fatalf("unexpected panic error: %+v", panicErr)
+		}
+		if !strings.Contains(string(panicErr.Stack), "panicInMember") {
+			t.Fatalf("expected stack to include panic site, got:\n%s", panicErr.Stack)
+		}
+	})
+
+	t.Run("batchReturnsPanic", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		panicVal := errors.New("panic value")
+		b := Batch{PanicsAsErrors: true}
+		b.Add(func(ctx context.Context) error {
+			panic(panicVal)
+		})
+		err := b.Run(ctx)
+
+		if !errors.Is(err, panicVal) {
+			t.Fatalf("expected error panic value to be unwrapped, got %v", err)
+		}
+	})
+
+	t.Run("contextErrorPanicsAreNotCancellations", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		failure := errors.New("failure")
+		b := Batch{PanicsAsErrors: true}
+		b.Add(func(ctx context.Context) error {
+			return failure
+		})
+		b.Add(func(ctx context.Context) error {
+			<-ctx.Done()
+			panic(ctx.Err())
+		})
+		err := b.Run(ctx)
+
+		var panicErr *PanicError
+		if !errors.Is(err, failure) || !errors.As(err, &panicErr) {
+			t.Fatalf("expected both the failure and the panic, got %v", err)
+		}
+
+		g := Group{PanicsAsErrors: true}
+		g.Add(func(ctx context.Context) error {
+			return failure
+		})
+		g.Add(func(ctx context.Context) error {
+			<-ctx.Done()
+			panic(ctx.Err())
+		})
+		err = g.Run(ctx)
+
+		if !errors.Is(err, failure) || !errors.As(err, &panicErr) {
+			t.Fatalf("expected both the failure and the panic, got %v", err)
+		}
+	})
+
+	t.Run("packageLevelSetting", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		SetPanicsAsErrors(true)
+		defer SetPanicsAsErrors(false)
+
+		err := GroupRun(ctx, func(ctx context.Context) error {
+			panic("group run panic")
+		})
+
+		var panicErr *PanicError
+		if !errors.As(err, &panicErr) || panicErr.Value != "group run panic" {
+			t.Fatalf("expected panic error, got %v", err)
+		}
+	})
+}
+
+func panicInMember() {
+	panic("member panic")
+}
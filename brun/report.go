@@ -0,0 +1,79 @@
+package brun
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// ShutdownReport describes how each member of a group run started and exited.
+type ShutdownReport struct {
+	// Members has a report for every member, in the order they were added.
+	Members []MemberReport
+}
+
+// MemberReport describes the run of a single member of a group.
+type MemberReport struct {
+	// Index is the position of the member, in the order it was added.
+	Index int
+	// Name is the name the member was added under, if any.
+	Name string
+	// Started and Exited are when the member's function was called and when it
+	// returned. Started is zero if the member was never started, as happens
+	// when the group shuts down while it waits on its dependencies, and Exited
+	// is zero if the member never returned.
+	Started, Exited time.Time
+	// Ready is when the member signalled it was ready via ReadyFunc. It is zero
+	// if the member never became ready.
+	Ready time.Time
+	// Err is the error the member returned, or a *PanicError if it panicked.
+	Err error
+	// Optional is set if the member was added as optional; see Member.
+	Optional bool
+	// Initiator is set on the member whose exit caused the group to shut down.
+	// If the group was instead cancelled by its context, no member is the
+	// initiator.
+	Initiator bool
+}
+
+// Initiator returns the report for the member that caused the group to shut
+// down, if any.
+func (r *ShutdownReport) Initiator() (MemberReport, bool) {
+	for _, m := range r.Members {
+		if m.Initiator {
+			return m, true
+		}
+	}
+	return MemberReport{}, false
+}
+
+// String formats the report as a concise summary, with one line per member.
+func (r *ShutdownReport) String() string {
+	var buf bytes.Buffer
+	for _, m := range r.Members {
+		fmt.Fprintf(&buf, "%s: ", m.label())
+		if m.Started.IsZero() {
+			buf.WriteString("did not start")
+		} else if m.Exited.IsZero() {
+			buf.WriteString("did not exit")
+		} else {
+			fmt.Fprintf(&buf, "ran %s", m.Exited.Sub(m.Started))
+			if m.Err != nil {
+				fmt.Fprintf(&buf, ", exited with: %s", m.Err)
+			}
+		}
+		if m.Optional {
+			buf.WriteString(" (optional)")
+		}
+		if m.Initiator {
+			buf.WriteString(" (initiated shutdown)")
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// label returns the member's name, falling back to its index if unnamed.
+func (m MemberReport) label() string {
+	return memberLabel(m.Index, m.Name)
+}
@@ -0,0 +1,40 @@
+package brun
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_ShutdownReport(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	report := &ShutdownReport{
+		Members: []MemberReport{
+			{
+				Index:     0,
+				Name:      "db",
+				Started:   start,
+				Exited:    start.Add(2 * time.Second),
+				Err:       errors.New("connection lost"),
+				Initiator: true,
+			},
+			{
+				Index:   1,
+				Started: start,
+				Exited:  start.Add(3 * time.Second),
+			},
+			{
+				Index:   2,
+				Name:    "stuck",
+				Started: start,
+			},
+		},
+	}
+
+	expected := "db: ran 2s, exited with: connection lost (initiated shutdown)\n" +
+		"member 1: ran 3s\n" +
+		"stuck: did not exit\n"
+	if s := report.String(); s != expected {
+		t.Fatalf("unexpected summary:\n%s", s)
+	}
+}
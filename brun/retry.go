@@ -2,6 +2,9 @@ package brun
 
 import (
 	"context"
+	"errors"
+	"runtime/pprof"
+	"runtime/trace"
 	"time"
 )
 
@@ -11,7 +14,8 @@ import (
 //
 // Note that the inner function has no error return. If the user of this
 // function wishes to handle an error, it must be done within the function body.
-// Panics will still propagate back to the group.
+// Panics will still propagate back to the group. Restarts are not logged, as
+// they're routine; use WithRetryHooks to observe them.
 func GapRetry(
 	gap time.Duration,
 	fn func(ctx context.Context),
@@ -20,23 +24,11 @@ func GapRetry(
 		gap = 10 * time.Millisecond
 	}
 
-	return func(ctx context.Context) error {
-		for {
-			start := time.Now()
-			execFnInContext(ctx, fn)
-			end := time.Now()
-			if ctx.Err() != nil {
-				return ctx.Err()
-			}
-			retryIn := start.Sub(end) + gap
-			select {
-			case <-time.After(retryIn):
-				continue
-			case <-ctx.Done():
-				return ctx.Err()
-			}
-		}
-	}
+	r := &Retrier{Policy: ConstantBackoff(gap)}
+	return r.Wrap(func(ctx context.Context) error {
+		fn(ctx)
+		return nil
+	})
 }
 
 // ExpBackoffRetry offers a similar API to GapRetry, but instead of fixed-sized
@@ -57,38 +49,278 @@ func ExpBackoffRetry(
 		max = min
 	}
 
+	r := &Retrier{
+		Policy: ExponentialBackoff(min, max),
+		Failed: func(ran time.Duration, err error) bool {
+			return ran <= min
+		},
+	}
+	return r.Wrap(func(ctx context.Context) error {
+		fn(ctx)
+		return nil
+	})
+}
+
+// GapRetryErr is like GapRetry, but for functions that return an error. Any
+// error other than a cancellation counts as a failure, and is reported to the
+// package-level logger as a warning along with the restart.
+//
+// If fn returns an error wrapped with PermanentErr, it's not retried; the
+// unwrapped error is returned instead. When the context is cancelled after a
+// failed attempt, a *RetryError holding the last error is returned.
+func GapRetryErr(
+	gap time.Duration,
+	fn func(ctx context.Context) error,
+) func(ctx context.Context) error {
+	if gap < 10*time.Millisecond {
+		gap = 10 * time.Millisecond
+	}
+
+	r := &Retrier{Policy: ConstantBackoff(gap)}
+	return r.Wrap(fn)
+}
+
+// ExpBackoffRetryErr is like ExpBackoffRetry, but for functions that return an
+// error. Rather than comparing the runtime to min, an attempt has failed if it
+// returns any error other than a cancellation: each consecutive failure
+// doubles the wait up to max, and a nil return resets it to min.
+//
+// Permanent errors and giving up are handled the same as in GapRetryErr.
+func ExpBackoffRetryErr(
+	min, max time.Duration,
+	fn func(ctx context.Context) error,
+) func(ctx context.Context) error {
+	if min < 10*time.Millisecond {
+		min = 10 * time.Millisecond
+	}
+	if max < min {
+		max = min
+	}
+
+	r := &Retrier{Policy: ExponentialBackoff(min, max)}
+	return r.Wrap(fn)
+}
+
+// ErrRetryLimit is the reason given in a *RetryError when a RetryPolicy
+// decided to stop retrying.
+var ErrRetryLimit = errors.New("brun: retry limit reached")
+
+// Retrier repeatedly runs a function, waiting between attempts as directed by
+// its policy. Every retry helper in this package is built on it; it can be
+// used directly when they don't offer the needed control.
+type Retrier struct {
+	// Policy decides how long to wait between attempts, and when to stop. If
+	// nil, ExponentialBackoff(10*time.Millisecond, 10*time.Second) is used.
+	Policy RetryPolicy
+
+	// Failed decides if an attempt failed, given how long it ran and the error
+	// it returned. If nil, an attempt has failed if it returned an error.
+	Failed func(ran time.Duration, err error) bool
+
+	// Logger receives each retry of a failed attempt. If nil, the
+	// package-level logger is used.
+	Logger Logger
+
+	// Hooks are called as attempts are made. If nil, any hooks set on the
+	// context with WithRetryHooks are used.
+	Hooks *RetryHooks
+
+	// Metrics counts each retry as a restart, keyed by the name of the member
+	// being retried. If nil, the package-level sink is used, if any.
+	Metrics Metrics
+}
+
+// Wrap returns a function that runs fn under the retrier, suitable to be added
+// to a Group.
+func (r *Retrier) Wrap(
+	fn func(ctx context.Context) error,
+) func(ctx context.Context) error {
 	return func(ctx context.Context) error {
-		backoffIndex := 0
-		for {
-			start := time.Now()
-			execFnInContext(ctx, fn)
-			end := time.Now()
-			runTime := end.Sub(start)
-			if ctx.Err() != nil {
-				return ctx.Err()
-			}
+		return r.Run(ctx, fn)
+	}
+}
 
-			if runTime > min {
-				backoffIndex = 0
-			} else {
-				backoffIndex++
-			}
-			gap := min
-			for i := 1; i < backoffIndex; i++ {
-				nextGap := gap * 2
-				if nextGap > max {
-					break
-				}
-				gap = nextGap
-			}
-			retryIn := start.Sub(end) + gap
+// Run calls fn until the context is cancelled, fn returns an error marked with
+// PermanentErr, or the policy stops retrying. Regardless of whether it
+// succeeded, fn is run again after each attempt; only the policy can decide
+// that one success is enough.
+//
+// When stopping, Run returns the permanent error unwrapped, or else the
+// reason it stopped, which is either the context's error or ErrRetryLimit.
+// If the last attempt failed, the reason is wrapped in a *RetryError holding
+// the attempt's error. If the context has a deadline that would pass before
+// the next attempt, Run gives up straight away with
+// context.DeadlineExceeded, rather than waiting for it.
+func (r *Retrier) Run(
+	ctx context.Context,
+	fn func(ctx context.Context) error,
+) error {
+	policy := r.Policy
+	if policy == nil {
+		policy = ExponentialBackoff(10*time.Millisecond, 10*time.Second)
+	}
+	failed := r.Failed
+	if failed == nil {
+		failed = func(ran time.Duration, err error) bool {
+			return err != nil
+		}
+	}
+	logger := getLogger(r.Logger)
+	clock := getClock(ctx)
+	hooks := getRetryHooks(ctx, r.Hooks)
+	name := memberName(ctx)
+	metrics := newMemberMetrics(getMetrics(r.Metrics), kindRetry, name)
+	attemptCtx, labels := withMemberLabels(ctx, kindRetry, name)
 
-			select {
-			case <-time.After(retryIn):
-				continue
-			case <-ctx.Done():
-				return ctx.Err()
+	var state RetryState
+	first := clock.Now()
+	for {
+		attempt := RetryAttempt{
+			Member:  name,
+			Attempt: state.Attempt + 1,
+		}
+		hooks.attemptStart(attempt)
+		start := clock.Now()
+		var err error
+		pprof.Do(attemptCtx, labels, func(ctx context.Context) {
+			traceAttempt(ctx, attempt.Attempt)
+			trace.WithRegion(ctx, regionRetryAttempt, func() {
+				err = execErrFnInContext(ctx, fn)
+			})
+		})
+		end := clock.Now()
+		state.Attempt++
+
+		attempt.Ran = end.Sub(start)
+		attempt.Err = err
+		attempt.Failed = failed(attempt.Ran, err) && !isCancelErr(ctx, err)
+		attempt.Failures = state.Failures + 1
+		if !attempt.Failed {
+			attempt.Failures = 0
+		}
+		hooks.attemptEnd(attempt)
+
+		if ctx.Err() != nil {
+			if err != nil && !isCancelErr(ctx, err) {
+				state.Err = err
 			}
+			return newRetryError(ctx.Err(), state)
+		}
+
+		if permErr, ok := err.(*permanentError); ok {
+			return permErr.err
+		} else if IsPermanent(err) {
+			return err
+		}
+
+		state.Ran = attempt.Ran
+		state.Elapsed = end.Sub(first)
+		state.Failures = attempt.Failures
+		state.Err = nil
+		if attempt.Failed {
+			state.Err = err
+		}
+
+		delay, ok := policy.Next(state)
+		if !ok {
+			return newRetryError(ErrRetryLimit, state)
+		}
+		state.Prev = delay
+
+		// The delay is measured from the start of the attempt, so a run that
+		// took longer than it is retried right away.
+		retryIn := start.Add(delay).Sub(end)
+		if retryIn < 0 {
+			retryIn = 0
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < retryIn {
+			return newRetryError(context.DeadlineExceeded, state)
+		}
+		attempt.Delay = retryIn
+		hooks.backoff(attempt)
+		metrics.restarted()
+		// Routine restarts are left to the hooks; only failures are logged.
+		if attempt.Failed {
+			logWarn(logger, "brun: attempt failed; retrying",
+				"name", name,
+				"ran", state.Ran,
+				"retry_in", retryIn,
+				"err", err)
+		}
+
+		region := trace.StartRegion(ctx, regionRetryBackoff)
+		retryChan, stop := clock.NewTimer(retryIn)
+		select {
+		case <-retryChan:
+			region.End()
+			continue
+		case <-ctx.Done():
+			stop()
+			region.End()
+			return newRetryError(ctx.Err(), state)
+		}
+	}
+}
+
+// newRetryError describes giving up on retries for the given reason. If the
+// last attempt didn't fail, the reason is returned as is. If it stopped due to
+// the retry limit after a success, nil is returned.
+func newRetryError(reason error, state RetryState) error {
+	if state.Err == nil {
+		if reason == ErrRetryLimit {
+			return nil
 		}
+		return reason
+	}
+	return &RetryError{
+		Attempts: state.Attempt,
+		Err:      reason,
+		Last:     state.Err,
+	}
+}
+
+// PermanentErr wraps err to mark it as one that retrying won't fix. The
+// error-aware retry helpers stop as soon as it's returned, and pass on the
+// original error. Returns nil if err is nil.
+func PermanentErr(err error) error {
+	if err == nil {
+		return nil
 	}
+	return &permanentError{err: err}
+}
+
+// PermanentIf wraps fn so that any error it returns that matches classify is
+// marked with PermanentErr. This allows errors to be classified without having
+// to change the function itself, e.g.:
+//
+//	ExpBackoffRetryErr(min, max, PermanentIf(isConfigErr, fn))
+func PermanentIf(
+	classify func(err error) bool,
+	fn func(ctx context.Context) error,
+) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		err := fn(ctx)
+		if err != nil && classify(err) {
+			return PermanentErr(err)
+		}
+		return err
+	}
+}
+
+// IsPermanent indicates if err was marked with PermanentErr.
+func IsPermanent(err error) bool {
+	var permErr *permanentError
+	return errors.As(err, &permErr)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
 }
@@ -0,0 +1,85 @@
+package brun
+
+import (
+	"context"
+	"time"
+)
+
+// RetryHooks are called by a Retrier as it runs, to allow retries to be
+// logged or counted. Any of them may be nil. They're called synchronously
+// from the retrying goroutine, so should return quickly.
+type RetryHooks struct {
+	// OnAttemptStart is called before each attempt.
+	OnAttemptStart func(attempt RetryAttempt)
+	// OnAttemptEnd is called after each attempt, with how long it ran and
+	// what it returned.
+	OnAttemptEnd func(attempt RetryAttempt)
+	// OnBackoff is called before waiting to retry, with how long the wait
+	// will be.
+	OnBackoff func(attempt RetryAttempt)
+}
+
+// RetryAttempt describes a single attempt made by a Retrier. Fields that
+// aren't known yet when a hook is called are left zero.
+type RetryAttempt struct {
+	// Member is the name of the group member, set task key or supervisor child
+	// the retries run under, if it has one.
+	Member string
+	// Attempt is the number of the attempt, starting at one.
+	Attempt int
+	// Ran is how long the attempt ran for.
+	Ran time.Duration
+	// Err is the error the attempt returned.
+	Err error
+	// Failed indicates if the attempt was considered a failure.
+	Failed bool
+	// Failures is the number of consecutive failed attempts, including this
+	// one.
+	Failures int
+	// Delay is how long until the next attempt.
+	Delay time.Duration
+}
+
+// WithRetryHooks returns a context under which any Retrier without hooks of
+// its own, including those behind GapRetry, ExpBackoffRetry and the like,
+// calls the given hooks.
+func WithRetryHooks(ctx context.Context, hooks RetryHooks) context.Context {
+	return context.WithValue(ctx, retryHooksKey{}, hooks)
+}
+
+type retryHooksKey struct{}
+
+// getRetryHooks returns the given hooks if set, otherwise those set on ctx.
+func getRetryHooks(ctx context.Context, hooks *RetryHooks) RetryHooks {
+	if hooks != nil {
+		return *hooks
+	}
+	ctxHooks, _ := ctx.Value(retryHooksKey{}).(RetryHooks)
+	return ctxHooks
+}
+
+func (h RetryHooks) attemptStart(attempt RetryAttempt) {
+	if h.OnAttemptStart != nil {
+		h.OnAttemptStart(attempt)
+	}
+}
+
+func (h RetryHooks) attemptEnd(attempt RetryAttempt) {
+	if h.OnAttemptEnd != nil {
+		h.OnAttemptEnd(attempt)
+	}
+}
+
+func (h RetryHooks) backoff(attempt RetryAttempt) {
+	if h.OnBackoff != nil {
+		h.OnBackoff(attempt)
+	}
+}
+
+// memberName returns the name of the member running under ctx, if any.
+func memberName(ctx context.Context) string {
+	name, _ := ctx.Value(memberNameKey{}).(string)
+	return name
+}
+
+type memberNameKey struct{}
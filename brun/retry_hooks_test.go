@@ -0,0 +1,97 @@
+package brun
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bennettjames/go-concurrency-experiments/brun/bruntest"
+)
+
+func Test_RetryHooks(t *testing.T) {
+	t.Run("reportsEachAttempt", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		clock := bruntest.NewFakeClock(time.Unix(0, 0))
+		var l sync.Mutex
+		var events []string
+		var backoffs []RetryAttempt
+		record := func(event string) func(RetryAttempt) {
+			return func(a RetryAttempt) {
+				l.Lock()
+				defer l.Unlock()
+				events = append(events, event)
+				if event == "backoff" {
+					backoffs = append(backoffs, a)
+				}
+			}
+		}
+		ctx = WithClock(ctx, clock)
+		ctx = WithRetryHooks(ctx, RetryHooks{
+			OnAttemptStart: record("start"),
+			OnAttemptEnd:   record("end"),
+			OnBackoff:      record("backoff"),
+		})
+
+		failure := errors.New("failure")
+		g := Group{}
+		g.AddNamed("flapper", ExpBackoffRetryErr(
+			10*time.Millisecond, 80*time.Millisecond,
+			func(ctx context.Context) error {
+				clock.Advance(2 * time.Millisecond)
+				return failure
+			}))
+		done := make(chan error, 1)
+		go func() {
+			done <- g.Run(ctx)
+		}()
+		for i := 0; i < 2; i++ {
+			clock.BlockUntil(1)
+			clock.Advance(clock.Pending()[0])
+		}
+		clock.BlockUntil(1)
+		cancel()
+		<-done
+
+		l.Lock()
+		defer l.Unlock()
+		expected := "start end backoff start end backoff start end backoff"
+		if got := strings.Join(events, " "); got != expected {
+			t.Fatalf("unexpected events: %s", got)
+		}
+		for i, delay := range []time.Duration{8, 18, 38} {
+			a := backoffs[i]
+			if a.Member != "flapper" || a.Attempt != i+1 || a.Failures != i+1 ||
+				!a.Failed || a.Err != failure || a.Ran != 2*time.Millisecond ||
+				a.Delay != delay*time.Millisecond {
+				t.Fatalf("unexpected backoff for attempt %d: %+v", i+1, a)
+			}
+		}
+	})
+
+	t.Run("retrierHooksOverrideContext", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		ctxCalls, ownCalls := 0, 0
+		ctx = WithRetryHooks(ctx, RetryHooks{
+			OnAttemptStart: func(RetryAttempt) { ctxCalls++ },
+		})
+		r := &Retrier{
+			Policy: MaxAttempts(2, ConstantBackoff(0)),
+			Hooks: &RetryHooks{
+				OnAttemptStart: func(RetryAttempt) { ownCalls++ },
+			},
+		}
+		r.Run(ctx, func(ctx context.Context) error {
+			return errors.New("failure")
+		})
+		if ctxCalls != 0 || ownCalls != 2 {
+			t.Fatalf("unexpected calls: context=%d, own=%d", ctxCalls, ownCalls)
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package brun
+
+import (
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy decides how long a Retrier waits between attempts, and when it
+// stops retrying. Policies can be composed by wrapping one another, e.g.:
+//
+//	MaxAttempts(5, FullJitter(ExponentialBackoff(min, max)))
+type RetryPolicy interface {
+	// Next returns how long after the start of the last attempt the next
+	// should begin, or false to stop retrying.
+	Next(state RetryState) (time.Duration, bool)
+}
+
+// RetryPolicyFunc adapts a plain function into a RetryPolicy.
+type RetryPolicyFunc func(state RetryState) (time.Duration, bool)
+
+// Next calls the underlying function.
+func (f RetryPolicyFunc) Next(state RetryState) (time.Duration, bool) {
+	return f(state)
+}
+
+// RetryState describes the attempts made so far by a Retrier.
+type RetryState struct {
+	// Attempt is the number of attempts made, including the one just finished.
+	Attempt int
+	// Failures is the number of consecutive failed attempts, or zero if the
+	// last attempt succeeded.
+	Failures int
+	// Ran is how long the last attempt ran for.
+	Ran time.Duration
+	// Elapsed is the time from the start of the first attempt to the end of
+	// the last.
+	Elapsed time.Duration
+	// Prev is the delay the policy returned for the previous attempt, or zero
+	// if this is the first.
+	Prev time.Duration
+	// Err is the error returned by the last attempt, if it failed.
+	Err error
+}
+
+// ConstantBackoff waits the same delay between every attempt.
+func ConstantBackoff(delay time.Duration) RetryPolicy {
+	return RetryPolicyFunc(func(state RetryState) (time.Duration, bool) {
+		return delay, true
+	})
+}
+
+// ExponentialBackoff waits min after a success or the first failure, and
+// doubles the delay with each consecutive failure after that, up to max.
+func ExponentialBackoff(min, max time.Duration) RetryPolicy {
+	return RetryPolicyFunc(func(state RetryState) (time.Duration, bool) {
+		delay := min
+		for i := 1; i < state.Failures && delay < max; i++ {
+			delay *= 2
+		}
+		if delay > max {
+			delay = max
+		}
+		return delay, true
+	})
+}
+
+// FullJitter randomizes the delays of the given policy to anywhere between
+// zero and the original delay. This spreads out the retries of many clients
+// that failed at the same time.
+func FullJitter(policy RetryPolicy) RetryPolicy {
+	return RetryPolicyFunc(func(state RetryState) (time.Duration, bool) {
+		delay, ok := policy.Next(state)
+		if !ok {
+			return 0, false
+		}
+		return randDuration(delay), true
+	})
+}
+
+// EqualJitter randomizes the delays of the given policy to between half the
+// original delay and all of it. Unlike FullJitter, this guarantees some wait.
+func EqualJitter(policy RetryPolicy) RetryPolicy {
+	return RetryPolicyFunc(func(state RetryState) (time.Duration, bool) {
+		delay, ok := policy.Next(state)
+		if !ok {
+			return 0, false
+		}
+		return delay/2 + randDuration(delay-delay/2), true
+	})
+}
+
+// DecorrelatedJitter waits a random delay between min and three times the
+// previous delay, capped at max. This grows much like exponential backoff,
+// but with far more spread. A success resets the delay to min.
+func DecorrelatedJitter(min, max time.Duration) RetryPolicy {
+	return RetryPolicyFunc(func(state RetryState) (time.Duration, bool) {
+		if state.Failures == 0 || state.Prev < min {
+			return min, true
+		}
+		upper := state.Prev * 3
+		if upper > max {
+			upper = max
+		}
+		if upper <= min {
+			return min, true
+		}
+		return min + randDuration(upper-min), true
+	})
+}
+
+// MaxAttempts stops retrying once n attempts have been made.
+func MaxAttempts(n int, policy RetryPolicy) RetryPolicy {
+	return RetryPolicyFunc(func(state RetryState) (time.Duration, bool) {
+		if state.Attempt >= n {
+			return 0, false
+		}
+		return policy.Next(state)
+	})
+}
+
+// MaxElapsed stops retrying once the next attempt would begin more than d
+// after the first attempt began.
+func MaxElapsed(d time.Duration, policy RetryPolicy) RetryPolicy {
+	return RetryPolicyFunc(func(state RetryState) (time.Duration, bool) {
+		delay, ok := policy.Next(state)
+		if !ok {
+			return 0, false
+		}
+		next := state.Elapsed - state.Ran + delay
+		if next < state.Elapsed {
+			next = state.Elapsed
+		}
+		if next > d {
+			return 0, false
+		}
+		return delay, true
+	})
+}
+
+// randDuration returns a random duration in [0, d).
+func randDuration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
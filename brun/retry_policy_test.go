@@ -0,0 +1,82 @@
+package brun
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_RetryPolicy(t *testing.T) {
+	failures := func(n int) RetryState {
+		return RetryState{Attempt: n, Failures: n}
+	}
+
+	t.Run("exponentialBackoffClampsAtMax", func(t *testing.T) {
+		policy := ExponentialBackoff(10*time.Millisecond, 50*time.Millisecond)
+		expected := []time.Duration{
+			10 * time.Millisecond, // success
+			10 * time.Millisecond,
+			20 * time.Millisecond,
+			40 * time.Millisecond,
+			50 * time.Millisecond,
+			50 * time.Millisecond,
+		}
+		for n, want := range expected {
+			if delay, ok := policy.Next(failures(n)); !ok || delay != want {
+				t.Fatalf("unexpected delay after %d failures: %s", n, delay)
+			}
+		}
+	})
+
+	t.Run("jitterStaysInRange", func(t *testing.T) {
+		base := ConstantBackoff(100 * time.Millisecond)
+		full, equal := FullJitter(base), EqualJitter(base)
+		for i := 0; i < 100; i++ {
+			if delay, _ := full.Next(failures(1)); delay < 0 || delay >= 100*time.Millisecond {
+				t.Fatalf("full jitter out of range: %s", delay)
+			}
+			if delay, _ := equal.Next(failures(1)); delay < 50*time.Millisecond || delay >= 100*time.Millisecond {
+				t.Fatalf("equal jitter out of range: %s", delay)
+			}
+		}
+	})
+
+	t.Run("decorrelatedJitterGrowsFromPrevious", func(t *testing.T) {
+		min, max := 10*time.Millisecond, 100*time.Millisecond
+		policy := DecorrelatedJitter(min, max)
+		if delay, _ := policy.Next(RetryState{Failures: 0, Prev: 50 * time.Millisecond}); delay != min {
+			t.Fatalf("expected success to reset delay; got %s", delay)
+		}
+		for i := 0; i < 100; i++ {
+			state := RetryState{Failures: 2, Prev: 20 * time.Millisecond}
+			if delay, _ := policy.Next(state); delay < min || delay >= 60*time.Millisecond {
+				t.Fatalf("decorrelated jitter out of range: %s", delay)
+			}
+			state.Prev = 80 * time.Millisecond
+			if delay, _ := policy.Next(state); delay < min || delay > max {
+				t.Fatalf("decorrelated jitter out of range: %s", delay)
+			}
+		}
+	})
+
+	t.Run("maxAttempts", func(t *testing.T) {
+		policy := MaxAttempts(3, ConstantBackoff(10*time.Millisecond))
+		if _, ok := policy.Next(failures(2)); !ok {
+			t.Fatalf("expected retry after 2 attempts")
+		}
+		if _, ok := policy.Next(failures(3)); ok {
+			t.Fatalf("expected no retry after 3 attempts")
+		}
+	})
+
+	t.Run("maxElapsed", func(t *testing.T) {
+		policy := MaxElapsed(100*time.Millisecond, ConstantBackoff(30*time.Millisecond))
+		state := RetryState{Elapsed: 60 * time.Millisecond, Ran: 10 * time.Millisecond}
+		if _, ok := policy.Next(state); !ok {
+			t.Fatalf("expected retry starting at 80ms")
+		}
+		state = RetryState{Elapsed: 85 * time.Millisecond, Ran: 10 * time.Millisecond}
+		if _, ok := policy.Next(state); ok {
+			t.Fatalf("expected no retry starting at 105ms")
+		}
+	})
+}
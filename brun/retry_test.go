@@ -2,8 +2,11 @@ package brun
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
+
+	"github.com/bennettjames/go-concurrency-experiments/brun/bruntest"
 )
 
 func Test_GapRetry(t *testing.T) {
@@ -144,3 +147,253 @@ func approximatelyApartBy(t1, t2 time.Time, apart time.Duration) bool {
 	diff := t2.Sub(t1)
 	return diff >= apart-6*time.Millisecond && diff <= apart+6*time.Millisecond
 }
+
+func Test_RetryErr(t *testing.T) {
+	failure := errors.New("failure")
+
+	t.Run("retriesFailures", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		attempts := 0
+		err := GapRetryErr(10*time.Millisecond, func(ctx context.Context) error {
+			attempts++
+			if attempts < 3 {
+				return failure
+			}
+			return nil
+		})(ctx)
+		if err != context.DeadlineExceeded {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if attempts < 5 {
+			t.Fatalf("expected at least 5 attempts; got %d", attempts)
+		}
+	})
+
+	t.Run("returnsLastErrorWhenGivingUp", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		attempts := 0
+		err := ExpBackoffRetryErr(10*time.Millisecond, 20*time.Millisecond,
+			func(ctx context.Context) error {
+				attempts++
+				return failure
+			})(ctx)
+		var retryErr *RetryError
+		if !errors.As(err, &retryErr) {
+			t.Fatalf("expected a retry error; got %v", err)
+		}
+		if retryErr.Last != failure || retryErr.Attempts != attempts {
+			t.Fatalf("unexpected retry error: %+v", retryErr)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected retry error to unwrap to the context error")
+		}
+	})
+
+	t.Run("stopsOnPermanentErrors", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		attempts := 0
+		err := GapRetryErr(10*time.Millisecond, func(ctx context.Context) error {
+			attempts++
+			if attempts == 2 {
+				return PermanentErr(failure)
+			}
+			return failure
+		})(ctx)
+		if err != failure || attempts != 2 {
+			t.Fatalf("unexpected result: %v after %d attempts", err, attempts)
+		}
+	})
+
+	t.Run("classifiesPermanentErrors", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		g := &Group{}
+		g.Add(ExpBackoffRetryErr(10*time.Millisecond, 20*time.Millisecond,
+			PermanentIf(
+				func(err error) bool { return err == failure },
+				func(ctx context.Context) error {
+					return failure
+				})))
+		if err := g.Run(ctx); err != failure {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("backsOffOnFailures", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		clock := bruntest.NewFakeClock(time.Unix(0, 0))
+		ctx = WithClock(ctx, clock)
+
+		attempts := make(chan time.Time)
+		done := make(chan error, 1)
+		min, max := 20*time.Millisecond, 40*time.Millisecond
+		go func() {
+			done <- ExpBackoffRetryErr(min, max, func(ctx context.Context) error {
+				attempts <- clock.Now()
+				return failure
+			})(ctx)
+		}()
+
+		prev := <-attempts
+		expectations := []time.Duration{
+			20 * time.Millisecond,
+			40 * time.Millisecond,
+			40 * time.Millisecond,
+		}
+		for i, gap := range expectations {
+			clock.BlockUntil(1)
+			clock.Advance(clock.Pending()[0])
+			next := <-attempts
+			if next.Sub(prev) != gap {
+				t.Fatalf(
+					"Execution at index %d not the right distance apart (diff: %s, expected: %s)",
+					i, next.Sub(prev), gap)
+			}
+			prev = next
+		}
+
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func Test_Retrier(t *testing.T) {
+	failure := errors.New("failure")
+
+	t.Run("stopsWhenPolicyDoes", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		attempts := 0
+		r := &Retrier{Policy: MaxAttempts(3, ConstantBackoff(time.Millisecond))}
+		err := r.Run(ctx, func(ctx context.Context) error {
+			attempts++
+			return failure
+		})
+		var retryErr *RetryError
+		if !errors.As(err, &retryErr) || retryErr.Err != ErrRetryLimit {
+			t.Fatalf("expected a retry limit error; got %v", err)
+		}
+		if attempts != 3 || retryErr.Attempts != 3 || retryErr.Last != failure {
+			t.Fatalf("unexpected retry error after %d attempts: %+v", attempts, retryErr)
+		}
+	})
+
+	t.Run("givesUpBeforeDeadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		r := &Retrier{Policy: ConstantBackoff(1 * time.Second)}
+		err := r.Run(ctx, func(ctx context.Context) error {
+			return failure
+		})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+			t.Fatalf("expected to give up without waiting; took %s", elapsed)
+		}
+	})
+
+	t.Run("classifiesFailures", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		var states []RetryState
+		r := &Retrier{
+			Policy: RetryPolicyFunc(func(state RetryState) (time.Duration, bool) {
+				states = append(states, state)
+				return 0, state.Attempt < 3
+			}),
+			Failed: func(ran time.Duration, err error) bool {
+				return err == failure
+			},
+		}
+		errs := []error{failure, errors.New("fine"), failure}
+		err := r.Run(ctx, func(ctx context.Context) error {
+			return errs[len(states)]
+		})
+		if !errors.Is(err, ErrRetryLimit) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for i, failures := range []int{1, 0, 1} {
+			if states[i].Failures != failures || states[i].Attempt != i+1 {
+				t.Fatalf("unexpected state for attempt %d: %+v", i+1, states[i])
+			}
+		}
+	})
+
+	t.Run("logsOnlyFailedAttempts", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		logs := &recordingLogger{}
+		r := &Retrier{
+			Policy: MaxAttempts(4, ConstantBackoff(0)),
+			Logger: logs,
+		}
+		errs := []error{nil, failure, nil, nil}
+		attempts := 0
+		r.Run(ctx, func(ctx context.Context) error {
+			attempts++
+			return errs[attempts-1]
+		})
+		if msgs := logs.messages(); len(msgs) != 1 || msgs[0] != "brun: attempt failed; retrying" {
+			t.Fatalf("expected only the failure to be logged, got %v", msgs)
+		}
+	})
+}
+
+func Test_RetryClock(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	clock := bruntest.NewFakeClock(time.Unix(0, 0))
+	ctx = WithClock(ctx, clock)
+
+	attempts := make(chan time.Time)
+	done := make(chan error, 1)
+	go func() {
+		done <- ExpBackoffRetry(20*time.Millisecond, 80*time.Millisecond,
+			func(ctx context.Context) {
+				attempts <- clock.Now()
+				clock.Advance(5 * time.Millisecond)
+			})(ctx)
+	}()
+
+	prev := <-attempts
+	expectations := []time.Duration{
+		20 * time.Millisecond,
+		40 * time.Millisecond,
+		80 * time.Millisecond,
+		80 * time.Millisecond,
+	}
+	for i, gap := range expectations {
+		clock.BlockUntil(1)
+		clock.Advance(clock.Pending()[0])
+		next := <-attempts
+		if next.Sub(prev) != gap {
+			t.Fatalf(
+				"Execution at index %d not the right distance apart (diff: %s, expected: %s)",
+				i, next.Sub(prev), gap)
+		}
+		prev = next
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
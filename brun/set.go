@@ -1,83 +1,481 @@
 package brun
 
 import (
+	"container/heap"
 	"context"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Set is a way to handle a dynamic group of goroutines. Unlike Batch or
 // Group, Set can have individual goroutines fail and be added while it is
 // running. Upon shutdown, all goroutines will exit
 type Set struct {
-	stack *fnStack
+	// Name identifies the set in metrics.
+	Name string
+
+	// PanicsAsErrors makes Run return a *PanicError when a task panics, rather
+	// than re-panicking. See SetPanicsAsErrors to enable this globally.
+	PanicsAsErrors bool
+
+	// Logger receives any failures that can't be returned from Run. If nil,
+	// the package-level logger is used.
+	Logger Logger
+
+	// Metrics receives measurements of the set's tasks. If nil, the
+	// package-level sink is used, if any.
+	Metrics Metrics
+
+	// ShutdownTimeout bounds how long Run will wait for running tasks to exit
+	// once the set is cancelled. If exceeded, Run returns a
+	// *ShutdownTimeoutError listing the tasks that are still running along with
+	// their goroutine stacks. If zero, Run waits indefinitely.
+	ShutdownTimeout time.Duration
+
+	// Order determines which waiting task is started next. It must be set
+	// before the first task is added.
+	Order QueueOrder
+
+	// Limit is the maximum number of tasks that will run at once. Tasks added
+	// while the set is at its limit wait in the queue until a running task
+	// exits. If zero or negative, every task is started as soon as it's added.
+	Limit int
+
+	// MaxPending is the number of tasks that may be waiting in the queue before
+	// TryAdd starts rejecting new ones. It only applies once the set is at its
+	// limit, and has no effect on Add. Tasks cancelled while waiting don't
+	// count towards it.
+	MaxPending int
+
+	queue *taskQueue
+
+	// running is the number of tasks currently running. Accessed atomically.
+	running int32
+
+	keysL sync.Mutex
+	keys  map[string]*Task
 }
 
 // NewSet initializes the set. This must be called to safely initialize the set.
 func NewSet() *Set {
 	return &Set{
-		stack: newFnStack(),
+		queue: newTaskQueue(),
+		keys:  map[string]*Task{},
 	}
 }
 
 // Run blocks and runs every function added to the set in a distinct goroutine.
 // Anything added before or after this being called will run until the
-// subfunction returns, or this is cancelled.
+// subfunction returns, or this is cancelled. Once cancelled, Run waits for
+// every running task to exit before returning. Tasks added between runs are
+// done straight away, but the set itself may be run again.
+//
+// While tasks are free to return whenever they like, a panic in any of them
+// will cancel the set; Run will then re-raise it, or return it as a
+// *PanicError if panics are returned as errors. The member index in the error
+// counts tasks in the order they were started.
 func (s *Set) Run(ctx context.Context) error {
+	ctx, task := traceRun(ctx, kindSet)
+	defer task.End()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	s.queue.open()
 
-	for {
-		nextFn, err := s.stack.Next(ctx)
+	asErrors := s.PanicsAsErrors || panicsAsErrors()
+	logger := getLogger(s.Logger)
+	metrics := newMemberMetrics(getMetrics(s.Metrics), kindSet, s.Name)
+
+	// panicChan holds the first panic raised by a task. Any later ones are
+	// logged and dropped.
+	panicChan := make(chan runErr, 1)
+
+	var wg sync.WaitGroup
+	var runningL sync.Mutex
+	running := map[int]*Task{}
+
+	// slots has room for every task that may run at once. Unused if the set
+	// has no limit.
+	var slots chan struct{}
+	if s.Limit > 0 {
+		slots = make(chan struct{}, s.Limit)
+	}
+
+	var stopErr error
+	for index := 0; ; {
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				stopErr = ctx.Err()
+			}
+			if stopErr != nil {
+				break
+			}
+		}
+		task, err := s.queue.Next(ctx)
 		if err != nil {
-			return err
+			stopErr = err
+			break
 		}
-		// todo (bs): I know errors are suppressed here, but I think panics should
-		// likely still bubble back. Also, the other ones do a full wait for this to
-		go func() {
-			ctx, cancel := context.WithCancel(ctx)
-			defer cancel()
-			nextFn(ctx)
-		}()
+		taskCtx, ok := task.start(ctx)
+		if !ok {
+			if slots != nil {
+				<-slots
+			}
+			continue
+		}
+		taskIndex := index
+		index++
+		runningL.Lock()
+		running[taskIndex] = task
+		runningL.Unlock()
+		atomic.AddInt32(&s.running, 1)
+		wg.Add(1)
+		spawn(taskCtx, kindSet, taskIndex, task.key, func(taskCtx context.Context) {
+			defer wg.Done()
+			if s.ShutdownTimeout > 0 {
+				atomic.StoreInt64(&task.goroutineID, goroutineID())
+			}
+			defer func() {
+				atomic.AddInt32(&s.running, -1)
+				if slots != nil {
+					<-slots
+				}
+			}()
+			re := execMember(taskCtx, metrics, taskIndex, task.key, func(ctx context.Context) error {
+				task.run(ctx)
+				return nil
+			})
+			task.finish(re)
+			s.releaseKey(task)
+			runningL.Lock()
+			delete(running, taskIndex)
+			runningL.Unlock()
+			if re.panic == nil {
+				return
+			}
+			select {
+			case panicChan <- re:
+			default:
+				logSuppressedPanic(logger, re)
+			}
+			cancel()
+		})
 	}
+
+	// Tasks that never got to run are done as well, as are any added from
+	// here on.
+	pending := 0
+	for _, task := range s.queue.close(stopErr) {
+		if task.abandon(stopErr) {
+			pending++
+		}
+		s.releaseKey(task)
+	}
+	if pending > 0 {
+		logWarn(logger, "brun: set stopped with tasks that never ran",
+			"pending", pending)
+	}
+
+	var timeoutErr *ShutdownTimeoutError
+	if !waitTimeout(getClock(ctx), &wg, s.ShutdownTimeout) {
+		timeoutErr = &ShutdownTimeoutError{
+			Timeout: s.ShutdownTimeout,
+			Err:     stopErr,
+		}
+		runningL.Lock()
+		var stuckIDs []int64
+		for _, task := range running {
+			stuckIDs = append(stuckIDs, atomic.LoadInt64(&task.goroutineID))
+		}
+		stacks := goroutineStacks(stuckIDs)
+		for index, task := range running {
+			timeoutErr.Stragglers = append(timeoutErr.Stragglers, Straggler{
+				Member: index,
+				Name:   task.key,
+				Stack:  stacks[atomic.LoadInt64(&task.goroutineID)],
+			})
+		}
+		runningL.Unlock()
+		sort.Slice(timeoutErr.Stragglers, func(i, j int) bool {
+			return timeoutErr.Stragglers[i].Member < timeoutErr.Stragglers[j].Member
+		})
+	}
+
+	select {
+	case re := <-panicChan:
+		if !asErrors {
+			if timeoutErr != nil {
+				logError(logger, "brun: suppressed error", "err", timeoutErr)
+			}
+			panic(re.panic)
+		}
+		if timeoutErr != nil {
+			return &MultiError{Errors: []error{re.panicError(), timeoutErr}}
+		}
+		return re.panicError()
+	default:
+	}
+	if timeoutErr != nil {
+		return timeoutErr
+	}
+	return stopErr
 }
 
 // Add will enqueue the given subfunction in the set. The function will run as
-// long as the set is active. If the set is closed, this will successfully
-// enqueue it but will never be run.
+// long as the set is active. If the set has stopped, the function is never
+// run, even if the set is later run again.
 func (s *Set) Add(fn func(context.Context)) {
-	s.stack.Add(fn)
+	s.AddTask(fn)
+}
+
+// AddTask enqueues the given subfunction in the set just like Add, and returns
+// a handle that can be used to cancel it or wait for it to exit. If the set has
+// stopped, the task is already done, with the error that stopped the set.
+func (s *Set) AddTask(fn func(context.Context)) *Task {
+	task := newTask(fn)
+	if err := s.queue.Add(task, s.Order); err != nil {
+		task.abandon(err)
+	}
+	return task
+}
+
+// TryAdd enqueues the given subfunction like AddTask, unless the set is at its
+// limit of running tasks and already has MaxPending tasks waiting. In that
+// case the task is rejected and false is returned.
+func (s *Set) TryAdd(fn func(context.Context)) (*Task, bool) {
+	task := newTask(fn)
+	full := func(pending int) bool {
+		if s.Limit <= 0 || int(atomic.LoadInt32(&s.running)) < s.Limit {
+			return false
+		}
+		return pending >= s.MaxPending
+	}
+	ok, err := s.queue.tryAdd(task, s.Order, full)
+	if !ok {
+		return nil, false
+	}
+	if err != nil {
+		task.abandon(err)
+	}
+	return task, true
+}
+
+// AddPriority enqueues the given subfunction in the set just like AddTask, with
+// a priority that is used to pick the next task to start when the set's order
+// is Priority. Higher priorities are started first.
+func (s *Set) AddPriority(priority int, fn func(context.Context)) *Task {
+	task := newTask(fn)
+	task.priority = priority
+	if err := s.queue.Add(task, s.Order); err != nil {
+		task.abandon(err)
+	}
+	return task
+}
+
+// AddKeyed enqueues the given subfunction in the set under a key. If a task is
+// already present under that key, it is cancelled and replaced: the new
+// function won't be started until the old one has exited, so at most one
+// function runs per key at a time.
+func (s *Set) AddKeyed(key string, fn func(context.Context)) *Task {
+	s.keysL.Lock()
+	defer s.keysL.Unlock()
+	return s.putKeyed(key, fn)
+}
+
+// Remove cancels the task under the given key. Returns false if there was no
+// such task.
+func (s *Set) Remove(key string) bool {
+	s.keysL.Lock()
+	defer s.keysL.Unlock()
+	task, ok := s.keys[key]
+	if !ok {
+		return false
+	}
+	delete(s.keys, key)
+	task.Cancel()
+	return true
 }
 
-// fnStack is a basic, (mostly) threadsafe stack for holding functions added to
-// a set. Functions can be added, and be retrieved via `Next`. While there are
-// no limitations on how many writers there can be at a time, the expectation is
+// Reconcile converges the keyed tasks in the set to the desired ones. Tasks
+// under keys that are not desired are cancelled, and desired keys with no
+// task are added. Keys that already have a task are left running as-is - to
+// change the function of a running key, use AddKeyed.
+func (s *Set) Reconcile(desired map[string]func(context.Context)) {
+	s.keysL.Lock()
+	defer s.keysL.Unlock()
+	for key, task := range s.keys {
+		if _, ok := desired[key]; !ok {
+			delete(s.keys, key)
+			task.Cancel()
+		}
+	}
+	for key, fn := range desired {
+		if task, ok := s.keys[key]; ok && !task.isDone() {
+			continue
+		}
+		s.putKeyed(key, fn)
+	}
+}
+
+// putKeyed adds a keyed task, replacing any existing one. keysL must be held.
+func (s *Set) putKeyed(key string, fn func(context.Context)) *Task {
+	task := newTask(fn)
+	task.key = key
+	if prev, ok := s.keys[key]; ok {
+		prev.Cancel()
+		if !prev.isDone() {
+			task.prev = prev
+		}
+	}
+	if err := s.queue.Add(task, s.Order); err != nil {
+		task.abandon(err)
+		return task
+	}
+	s.keys[key] = task
+	return task
+}
+
+// releaseKey removes the task from the set's keys if it's still the task
+// registered under its key.
+func (s *Set) releaseKey(task *Task) {
+	if task.key == "" {
+		return
+	}
+	s.keysL.Lock()
+	defer s.keysL.Unlock()
+	if s.keys[task.key] == task {
+		delete(s.keys, task.key)
+	}
+}
+
+// QueueOrder is the order in which a Set starts the tasks that are waiting to
+// be run.
+type QueueOrder int
+
+const (
+	// LIFO starts the most recently added task first. It is the default.
+	LIFO QueueOrder = iota
+	// FIFO starts tasks in the order they were added.
+	FIFO
+	// Priority starts the task with the highest priority first, as given to
+	// AddPriority. Tasks of equal priority start in the order they were added;
+	// tasks added without a priority have a priority of zero.
+	Priority
+)
+
+// taskQueue is a basic, (mostly) threadsafe queue for holding tasks added to a
+// set. Tasks can be added, and be retrieved via `Next`. While there are no
+// limitations on how many writers there can be at a time, the expectation is
 // that there will only be one reader at a time.
-type fnStack struct {
+type taskQueue struct {
 	l      sync.Mutex
-	stack  []func(context.Context)
+	heap   taskHeap
+	seq    uint64
 	notify chan (struct{})
+
+	// err is set once the queue has been closed, after which it accepts no
+	// more tasks until it's opened again.
+	err error
 }
 
-// newFnStack initializes a new fnStack. This must be called to safely
-// initialize the stack.
-func newFnStack() *fnStack {
-	return &fnStack{
+// newTaskQueue initializes a new taskQueue. This must be called to safely
+// initialize the queue.
+func newTaskQueue() *taskQueue {
+	return &taskQueue{
 		notify: make(chan struct{}, 1),
 	}
 }
 
-// Add will put the given function on the stack, and notify any waiters that the
-// queue state has changed.
-func (s *fnStack) Add(fn func(context.Context)) {
-	s.l.Lock()
-	defer s.l.Unlock()
+// Add will put the given task in the queue, and notify any waiters that the
+// queue state has changed. The order must be the same for every call. If the
+// queue has been closed, the task is not added and the error it was closed
+// with is returned.
+func (q *taskQueue) Add(task *Task, order QueueOrder) error {
+	q.l.Lock()
+	defer q.l.Unlock()
+	if q.err != nil {
+		return q.err
+	}
+	q.push(task, order)
+	return nil
+}
+
+// tryAdd adds the task like Add, unless full reports that the queue can't
+// accept it given the number of tasks currently waiting. Returns false if the
+// task was rejected; a closed queue doesn't reject tasks, but returns its
+// error like Add.
+func (q *taskQueue) tryAdd(
+	task *Task,
+	order QueueOrder,
+	full func(pending int) bool,
+) (bool, error) {
+	q.l.Lock()
+	defer q.l.Unlock()
+	if q.err != nil {
+		return true, q.err
+	}
+	if full(q.heap.Len()) {
+		// Tasks cancelled while waiting are only dropped once popped, so they
+		// may be all that's taking up room.
+		q.prune()
+		if full(q.heap.Len()) {
+			return false, nil
+		}
+	}
+	q.push(task, order)
+	return true, nil
+}
+
+// open lets the queue accept tasks again after being closed.
+func (q *taskQueue) open() {
+	q.l.Lock()
+	defer q.l.Unlock()
+	q.err = nil
+}
 
-	s.stack = append(s.stack, fn)
+// prune drops every task that was cancelled while waiting. The lock must be
+// held.
+func (q *taskQueue) prune() {
+	live := q.heap.tasks[:0]
+	for _, task := range q.heap.tasks {
+		if !task.isDone() {
+			live = append(live, task)
+		}
+	}
+	for i := len(live); i < len(q.heap.tasks); i++ {
+		q.heap.tasks[i] = nil
+	}
+	q.heap.tasks = live
+	heap.Init(&q.heap)
+}
 
-	// Adds a notification to the queue. Falls through if it's full: the stack is
+// close stops the queue from accepting tasks, with err given to anyone that
+// tries to add one. Returns the tasks that were still waiting, in order.
+func (q *taskQueue) close(err error) []*Task {
+	q.l.Lock()
+	defer q.l.Unlock()
+	q.err = err
+	var tasks []*Task
+	for q.heap.Len() > 0 {
+		tasks = append(tasks, heap.Pop(&q.heap).(*Task))
+	}
+	return tasks
+}
+
+// push adds the task and notifies any waiters. The lock must be held.
+func (q *taskQueue) push(task *Task, order QueueOrder) {
+	q.seq++
+	task.seq = q.seq
+	q.heap.order = order
+	heap.Push(&q.heap, task)
+
+	// Adds a notification to the queue. Falls through if it's full: the queue is
 	// designed to only be read by one thread at a time
 	select {
-	case s.notify <- struct{}{}:
+	case q.notify <- struct{}{}:
 	default:
 	}
 }
@@ -85,15 +483,15 @@ func (s *fnStack) Add(fn func(context.Context)) {
 // Next will wait until a value become available on the queue, or the provided
 // context is cancelled. Will only return an error on cancellation (in which
 // case the context error is returned).
-func (s *fnStack) Next(
+func (q *taskQueue) Next(
 	ctx context.Context,
-) (fn func(context.Context), err error) {
+) (task *Task, err error) {
 	for {
-		if next, ok := s.tryPop(); ok {
+		if next, ok := q.tryPop(); ok {
 			return next, nil
 		}
 		select {
-		case <-s.notify:
+		case <-q.notify:
 			continue
 		case <-ctx.Done():
 			return nil, ctx.Err()
@@ -101,16 +499,55 @@ func (s *fnStack) Next(
 	}
 }
 
-// tryPop will get and return the next element in the stack if it's available,
-// and return false if the stack is empty.
-func (s *fnStack) tryPop() (fn func(context.Context), ok bool) {
-	s.l.Lock()
-	defer s.l.Unlock()
-	stackSize := len(s.stack)
-	if stackSize == 0 {
+// tryPop will get and return the next task in the queue if it's available,
+// and return false if the queue is empty.
+func (q *taskQueue) tryPop() (task *Task, ok bool) {
+	q.l.Lock()
+	defer q.l.Unlock()
+	if q.heap.Len() == 0 {
 		return nil, false
 	}
-	v := s.stack[stackSize-1]
-	s.stack = s.stack[:stackSize-1]
-	return v, true
+	return heap.Pop(&q.heap).(*Task), true
+}
+
+// taskHeap implements heap.Interface, with the task that should be started
+// next according to its order at the top.
+type taskHeap struct {
+	order QueueOrder
+	tasks []*Task
+}
+
+func (h *taskHeap) Len() int {
+	return len(h.tasks)
+}
+
+func (h *taskHeap) Less(i, j int) bool {
+	a, b := h.tasks[i], h.tasks[j]
+	switch h.order {
+	case FIFO:
+		return a.seq < b.seq
+	case Priority:
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		return a.seq < b.seq
+	default:
+		return a.seq > b.seq
+	}
+}
+
+func (h *taskHeap) Swap(i, j int) {
+	h.tasks[i], h.tasks[j] = h.tasks[j], h.tasks[i]
+}
+
+func (h *taskHeap) Push(x interface{}) {
+	h.tasks = append(h.tasks, x.(*Task))
+}
+
+func (h *taskHeap) Pop() interface{} {
+	last := len(h.tasks) - 1
+	task := h.tasks[last]
+	h.tasks[last] = nil
+	h.tasks = h.tasks[:last]
+	return task
 }
@@ -2,6 +2,7 @@ package brun
 
 import (
 	"context"
+	"errors"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -47,3 +48,449 @@ func Test_Set(t *testing.T) {
 		t.Fatalf("Expected 10 additions; got %d", v)
 	}
 }
+
+func Test_SetPanics(t *testing.T) {
+	t.Run("repanics", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		set := NewSet()
+		cancelled := make(chan struct{})
+		set.Add(func(ctx context.Context) {
+			<-ctx.Done()
+			close(cancelled)
+		})
+		set.Add(func(context.Context) {
+			panic("task panic")
+		})
+
+		runPanic := getPanic(ctx, func(ctx context.Context) {
+			t.Error(set.Run(ctx))
+		})
+		if runPanic != "task panic" {
+			t.Fatalf("Expected panic to be propagated; got %v", runPanic)
+		}
+		select {
+		case <-cancelled:
+		case <-ctx.Done():
+			t.Fatal("Expected other tasks to be cancelled")
+		}
+	})
+
+	t.Run("panicsAsErrors", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		set := NewSet()
+		set.PanicsAsErrors = true
+		set.Add(func(context.Context) {
+			panic("task panic")
+		})
+
+		err := set.Run(ctx)
+		var panicErr *PanicError
+		if !errors.As(err, &panicErr) || panicErr.Value != "task panic" {
+			t.Fatalf("Expected panic error; got %v", err)
+		}
+	})
+}
+
+func Test_SetShutdown(t *testing.T) {
+	t.Run("waitsForTasks", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		set := NewSet()
+		var exited int64
+		for i := 0; i < 3; i++ {
+			set.Add(func(ctx context.Context) {
+				<-ctx.Done()
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddInt64(&exited, 1)
+			})
+		}
+		go func() {
+			time.Sleep(5 * time.Millisecond)
+			cancel()
+		}()
+
+		if err := set.Run(ctx); err != context.Canceled {
+			t.Fatalf("Expected cancel error; got %v", err)
+		}
+		if v := atomic.LoadInt64(&exited); v != 3 {
+			t.Fatalf("Expected every task to exit before returning; got %d", v)
+		}
+	})
+
+	t.Run("reportsStragglers", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		set := NewSet()
+		set.ShutdownTimeout = 10 * time.Millisecond
+		release := make(chan struct{})
+		defer close(release)
+		set.Add(func(ctx context.Context) {
+			<-ctx.Done()
+		})
+		set.Add(func(ctx context.Context) {
+			<-release
+		})
+		go func() {
+			time.Sleep(5 * time.Millisecond)
+			cancel()
+		}()
+
+		err := set.Run(ctx)
+		var timeoutErr *ShutdownTimeoutError
+		if !errors.As(err, &timeoutErr) {
+			t.Fatalf("Expected shutdown timeout error; got %v", err)
+		}
+		if len(timeoutErr.Stragglers) != 1 {
+			t.Fatalf("Expected one straggler; got %v", timeoutErr.Stragglers)
+		}
+		if len(timeoutErr.Stragglers[0].Stack) == 0 {
+			t.Fatal("Expected straggler's stack to be captured")
+		}
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected error to wrap the cause of shutdown; got %v", err)
+		}
+	})
+
+	t.Run("reportsPanicAlongsideStragglers", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		set := NewSet()
+		set.PanicsAsErrors = true
+		set.ShutdownTimeout = 10 * time.Millisecond
+		release := make(chan struct{})
+		defer close(release)
+		started := make(chan struct{})
+		set.Add(func(ctx context.Context) {
+			close(started)
+			<-release
+		})
+		set.Add(func(context.Context) {
+			<-started
+			panic("task panic")
+		})
+
+		err := set.Run(ctx)
+		multiErr, ok := err.(*MultiError)
+		if !ok || len(multiErr.Errors) != 2 {
+			t.Fatalf("Expected panic and timeout errors; got %v", err)
+		}
+		var panicErr *PanicError
+		var timeoutErr *ShutdownTimeoutError
+		if !errors.As(multiErr.Errors[0], &panicErr) ||
+			!errors.As(multiErr.Errors[1], &timeoutErr) {
+			t.Fatalf("Expected panic then timeout error; got %v", multiErr.Errors)
+		}
+	})
+
+	t.Run("finishesQueuedTasks", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		set := NewSet()
+		set.Limit = 1
+		set.Order = FIFO
+		set.Logger = &recordingLogger{}
+		started := make(chan struct{})
+		set.Add(func(ctx context.Context) {
+			close(started)
+			<-ctx.Done()
+		})
+		queued := set.AddTask(func(context.Context) {
+			t.Error("Expected queued task to never run")
+		})
+		go func() {
+			<-started
+			cancel()
+		}()
+
+		if err := set.Run(ctx); err != context.Canceled {
+			t.Fatalf("Expected cancel error; got %v", err)
+		}
+		select {
+		case <-queued.Done():
+		default:
+			t.Fatal("Expected queued task to be done")
+		}
+		if err := queued.Err(); err != context.Canceled {
+			t.Fatalf("Expected queued task to have the cancel error; got %v", err)
+		}
+	})
+
+	t.Run("finishesTasksAddedAfterRun", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		set := NewSet()
+		set.Run(ctx)
+		added := []*Task{
+			set.AddTask(func(context.Context) {}),
+			set.AddPriority(1, func(context.Context) {}),
+			set.AddKeyed("key", func(context.Context) {}),
+		}
+		if task, ok := set.TryAdd(func(context.Context) {}); ok {
+			added = append(added, task)
+		} else {
+			t.Fatal("Expected TryAdd to return a task")
+		}
+		for i, task := range added {
+			select {
+			case <-task.Done():
+			default:
+				t.Fatalf("Expected task %d to be done", i)
+			}
+			if err := task.Err(); err != context.Canceled {
+				t.Fatalf("Expected task %d to have the cancel error; got %v", i, err)
+			}
+		}
+	})
+}
+
+func Test_SetRunAgain(t *testing.T) {
+	set := NewSet()
+	for run := 0; run < 2; run++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		done := make(chan error, 1)
+		go func() {
+			done <- set.Run(ctx)
+		}()
+		// Tasks added before the second run starts would be done straight
+		// away, so wait for it to open the queue.
+		for {
+			set.queue.l.Lock()
+			open := set.queue.err == nil
+			set.queue.l.Unlock()
+			if open {
+				break
+			}
+			if ctx.Err() != nil {
+				t.Fatalf("Expected run %d to accept tasks", run)
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		task := set.AddTask(func(context.Context) {})
+		select {
+		case <-task.Done():
+		case <-ctx.Done():
+			t.Fatalf("Expected task to run in run %d", run)
+		}
+		if err := task.Err(); err != nil {
+			t.Fatalf("Expected task to exit cleanly in run %d; got %v", run, err)
+		}
+		cancel()
+		if err := <-done; err != context.Canceled {
+			t.Fatalf("Expected cancel error from run %d; got %v", run, err)
+		}
+	}
+}
+
+func Test_SetKeyed(t *testing.T) {
+	t.Run("replaceWaitsForPrevious", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		set := NewSet()
+		go set.Run(ctx)
+
+		var running int64
+		started := make(chan struct{}, 2)
+		worker := func(ctx context.Context) {
+			if atomic.AddInt64(&running, 1) > 1 {
+				t.Error("Expected at most one task per key")
+			}
+			started <- struct{}{}
+			<-ctx.Done()
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt64(&running, -1)
+		}
+		first := set.AddKeyed("tenant", worker)
+		<-started
+		second := set.AddKeyed("tenant", worker)
+		<-started
+
+		if first.Err() != context.Canceled {
+			t.Fatalf("Expected replaced task to be cancelled; got %v", first.Err())
+		}
+		if second.Key() != "tenant" {
+			t.Fatalf("Unexpected key %q", second.Key())
+		}
+		if !set.Remove("tenant") {
+			t.Fatal("Expected key to be removed")
+		}
+		<-second.Done()
+		if set.Remove("tenant") {
+			t.Fatal("Expected key to be gone after removal")
+		}
+	})
+
+	t.Run("reconcile", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		set := NewSet()
+		go set.Run(ctx)
+
+		var starts int64
+		worker := func(ctx context.Context) {
+			atomic.AddInt64(&starts, 1)
+			<-ctx.Done()
+		}
+		a := set.AddKeyed("a", worker)
+		b := set.AddKeyed("b", worker)
+
+		set.Reconcile(map[string]func(context.Context){
+			"b": worker,
+			"c": worker,
+		})
+		<-a.Done()
+
+		set.keysL.Lock()
+		keys := len(set.keys)
+		unchanged := set.keys["b"] == b
+		set.keysL.Unlock()
+		if keys != 2 || !unchanged {
+			t.Fatalf("Expected keys b and c with b untouched; got %d keys", keys)
+		}
+		select {
+		case <-b.Done():
+			t.Fatal("Expected b to keep running")
+		default:
+		}
+	})
+}
+
+func Test_taskQueueOrder(t *testing.T) {
+	popOrder := func(order QueueOrder, priorities ...int) []int {
+		q := newTaskQueue()
+		for i, priority := range priorities {
+			task := newTask(nil)
+			task.priority = priority
+			task.key = string(rune('a' + i))
+			q.Add(task, order)
+		}
+		var popped []int
+		for {
+			task, ok := q.tryPop()
+			if !ok {
+				return popped
+			}
+			popped = append(popped, int(task.key[0]-'a'))
+		}
+	}
+	check := func(name string, got []int, expected ...int) {
+		if len(got) != len(expected) {
+			t.Fatalf("%s: expected %v; got %v", name, expected, got)
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Fatalf("%s: expected %v; got %v", name, expected, got)
+			}
+		}
+	}
+
+	check("LIFO", popOrder(LIFO, 0, 0, 0, 0), 3, 2, 1, 0)
+	check("FIFO", popOrder(FIFO, 0, 0, 0, 0), 0, 1, 2, 3)
+	check("Priority", popOrder(Priority, 0, 5, 1, 5), 1, 3, 2, 0)
+}
+
+func Test_SetLimit(t *testing.T) {
+	t.Run("capsRunningTasks", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		set := NewSet()
+		set.Limit = 2
+		set.Order = FIFO
+		release := make(chan struct{})
+		var running, maxRunning int64
+		var tasks []*Task
+		for i := 0; i < 5; i++ {
+			tasks = append(tasks, set.AddTask(func(ctx context.Context) {
+				now := atomic.AddInt64(&running, 1)
+				defer atomic.AddInt64(&running, -1)
+				for {
+					prev := atomic.LoadInt64(&maxRunning)
+					if now <= prev || atomic.CompareAndSwapInt64(&maxRunning, prev, now) {
+						break
+					}
+				}
+				<-release
+			}))
+		}
+		go set.Run(ctx)
+
+		time.Sleep(10 * time.Millisecond)
+		if v := atomic.LoadInt64(&running); v != 2 {
+			t.Fatalf("Expected 2 running tasks; got %d", v)
+		}
+		close(release)
+		for _, task := range tasks {
+			<-task.Done()
+		}
+		if v := atomic.LoadInt64(&maxRunning); v != 2 {
+			t.Fatalf("Expected at most 2 tasks at once; got %d", v)
+		}
+	})
+
+	t.Run("tryAddRejectsWhenFull", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		set := NewSet()
+		set.Limit = 1
+		set.MaxPending = 1
+		release := make(chan struct{})
+		defer close(release)
+		block := func(ctx context.Context) {
+			<-release
+		}
+		go set.Run(ctx)
+
+		first, ok := set.TryAdd(block)
+		if !ok {
+			t.Fatal("Expected first task to be accepted")
+		}
+		for atomic.LoadInt32(&set.running) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		if _, ok := set.TryAdd(block); !ok {
+			t.Fatal("Expected second task to be queued")
+		}
+		if _, ok := set.TryAdd(block); ok {
+			t.Fatal("Expected third task to be rejected")
+		}
+		first.Cancel()
+	})
+
+	t.Run("tryAddIgnoresCancelledTasks", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		set := NewSet()
+		set.Limit = 1
+		set.MaxPending = 1
+		release := make(chan struct{})
+		defer close(release)
+		block := func(ctx context.Context) {
+			<-release
+		}
+		go set.Run(ctx)
+
+		set.AddTask(block)
+		for atomic.LoadInt32(&set.running) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		queued, ok := set.TryAdd(block)
+		if !ok {
+			t.Fatal("Expected second task to be queued")
+		}
+		queued.Cancel()
+		if _, ok := set.TryAdd(block); !ok {
+			t.Fatal("Expected cancelled task to make room for another")
+		}
+	})
+}
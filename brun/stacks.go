@@ -0,0 +1,60 @@
+package brun
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+)
+
+// goroutineID returns the runtime's identifier for the calling goroutine, as
+// parsed from the header of its stack trace. Returns 0 if it can't be parsed.
+//
+// The runtime deliberately doesn't expose this. It's only used to
+// pick out the stacks of stuck members when diagnosing a shutdown timeout, and
+// must never be used for anything that affects control flow.
+func goroutineID() int64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		buf = buf[:i]
+	}
+	id, err := strconv.ParseInt(string(buf), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+// goroutineStacks returns the current stack trace of each of the goroutines
+// with the given IDs. Goroutines that have exited are omitted.
+func goroutineStacks(ids []int64) map[int64][]byte {
+	wanted := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	stacks := make(map[int64][]byte, len(ids))
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		header := bytes.TrimPrefix(stack, []byte("goroutine "))
+		i := bytes.IndexByte(header, ' ')
+		if i <= 0 {
+			continue
+		}
+		id, err := strconv.ParseInt(string(header[:i]), 10, 64)
+		if err == nil && wanted[id] {
+			stacks[id] = stack
+		}
+	}
+	return stacks
+}
@@ -0,0 +1,29 @@
+package brun
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_goroutineStacks(t *testing.T) {
+	idChan := make(chan int64)
+	release := make(chan struct{})
+	defer close(release)
+	go func() {
+		idChan <- goroutineID()
+		stuckMember(release)
+	}()
+	id := <-idChan
+
+	if id == 0 || id == goroutineID() {
+		t.Fatalf("expected a distinct goroutine ID, got %d", id)
+	}
+	stacks := goroutineStacks([]int64{id})
+	if !bytes.Contains(stacks[id], []byte("stuckMember")) {
+		t.Fatalf("expected stack of other goroutine, got:\n%s", stacks[id])
+	}
+}
+
+func stuckMember(release chan struct{}) {
+	<-release
+}
@@ -0,0 +1,306 @@
+package brun
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RestartStrategy determines which of a Supervisor's children are restarted
+// when one of them exits.
+type RestartStrategy int
+
+const (
+	// OneForOne restarts only the child that exited.
+	OneForOne RestartStrategy = iota
+	// OneForAll stops every other child, then restarts all of them.
+	OneForAll
+	// RestForOne stops the children added after the one that exited, then
+	// restarts it along with them.
+	RestForOne
+)
+
+// RestartPolicy determines whether a Supervisor's child is restarted when it
+// exits.
+type RestartPolicy int
+
+const (
+	// Permanent children are always restarted.
+	Permanent RestartPolicy = iota
+	// Transient children are only restarted if they exit abnormally: with an
+	// error that isn't caused by cancellation, or a panic.
+	Transient
+	// Temporary children are never restarted, including when other children
+	// are restarted under the OneForAll or RestForOne strategies.
+	Temporary
+)
+
+// ChildSpec describes a function to be run by a Supervisor.
+type ChildSpec struct {
+	// Name identifies the child in logs and errors.
+	Name string
+	// Run is the function to execute.
+	Run func(ctx context.Context) error
+	// Restart decides if the child is restarted after it exits.
+	Restart RestartPolicy
+}
+
+// Supervisor runs a set of children, restarting them as they exit according
+// to a strategy and their individual policies. Unlike a Group, a child
+// exiting doesn't end the supervisor; it only fails if its children need to
+// be restarted too often, which suggests restarting them won't help.
+//
+// Panics in children are recovered and treated as abnormal exits.
+type Supervisor struct {
+	// Name identifies the supervisor in metrics.
+	Name string
+
+	// Strategy determines which children are restarted when one exits.
+	Strategy RestartStrategy
+
+	// MaxRestarts and Window set the restart intensity: if more than
+	// MaxRestarts restarts are needed within any period of Window, the
+	// supervisor stops all its children and fails with a *RestartLimitError.
+	// Each defaults separately when zero, to 3 restarts and 5 seconds
+	// respectively. A negative MaxRestarts allows no restarts at all.
+	MaxRestarts int
+	Window      time.Duration
+
+	// Logger receives the exits of children that are not otherwise returned.
+	// If nil, the package-level logger is used.
+	Logger Logger
+
+	// Metrics receives measurements of the supervisor's children, including
+	// their restarts. If nil, the package-level sink is used, if any.
+	Metrics Metrics
+
+	l        sync.Mutex
+	children []ChildSpec
+}
+
+// Add includes the given child. Children are started in the order they're
+// added, which is also the order the RestForOne strategy relies on.
+func (s *Supervisor) Add(spec ChildSpec) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.children = append(s.children, spec)
+}
+
+// Run starts every child and supervises them until ctx is cancelled or the
+// restart intensity is exceeded. Either way, every child is cancelled and
+// waited on before it returns.
+func (s *Supervisor) Run(ctx context.Context) error {
+	s.l.Lock()
+	children := append([]ChildSpec(nil), s.children...)
+	s.l.Unlock()
+
+	maxRestarts, window := s.MaxRestarts, s.Window
+	if maxRestarts == 0 {
+		maxRestarts = 3
+	} else if maxRestarts < 0 {
+		maxRestarts = 0
+	}
+	if window <= 0 {
+		window = 5 * time.Second
+	}
+
+	ctx, task := traceRun(ctx, kindSupervisor)
+	defer task.End()
+	r := &supervisorRun{
+		ctx:         ctx,
+		strategy:    s.Strategy,
+		maxRestarts: maxRestarts,
+		window:      window,
+		logger:      getLogger(s.Logger),
+		metrics:     newMemberMetrics(getMetrics(s.Metrics), kindSupervisor, s.Name),
+		children:    children,
+		exits:       make(chan runErr, len(children)),
+		states:      make([]childState, len(children)),
+	}
+	return r.run()
+}
+
+// childState tracks a single child of a supervisor run.
+type childState struct {
+	// active is set on children that are either running or waiting to be
+	// restarted; children that have exited for good are inactive.
+	active  bool
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	// restarting is set on children that were stopped so that they can be
+	// restarted along with a sibling.
+	restarting bool
+}
+
+// supervisorRun holds the state of a single run of a supervisor.
+type supervisorRun struct {
+	ctx         context.Context
+	strategy    RestartStrategy
+	maxRestarts int
+	window      time.Duration
+	logger      Logger
+	metrics     memberMetrics
+	children    []ChildSpec
+
+	// exits is large enough to hold a value from every child, as each child
+	// is only ever running once at a time.
+	exits   chan runErr
+	states  []childState
+	running int
+
+	restarts []time.Time
+	stopping bool
+	failErr  error
+}
+
+func (r *supervisorRun) run() error {
+	for i := range r.children {
+		r.states[i].active = true
+		r.start(i)
+	}
+
+	done := r.ctx.Done()
+	for r.running > 0 || !r.stopping {
+		select {
+		case re := <-r.exits:
+			r.handleExit(re)
+		case <-done:
+			done = nil
+			r.stop()
+		}
+	}
+	if r.failErr != nil {
+		return r.failErr
+	}
+	return r.ctx.Err()
+}
+
+func (r *supervisorRun) start(index int) {
+	spec := r.children[index]
+	state := &r.states[index]
+	state.ctx, state.cancel = context.WithCancel(r.ctx)
+	state.running = true
+	state.restarting = false
+	r.running++
+
+	ctx := state.ctx
+	spawn(ctx, kindSupervisor, index, spec.Name, func(ctx context.Context) {
+		r.exits <- execMember(ctx, r.metrics, index, spec.Name, spec.Run)
+	})
+}
+
+// stop cancels every running child, after which the supervisor exits once
+// they have all returned.
+func (r *supervisorRun) stop() {
+	r.stopping = true
+	for i := range r.states {
+		if r.states[i].running {
+			r.states[i].cancel()
+		}
+	}
+}
+
+func (r *supervisorRun) handleExit(re runErr) {
+	state := &r.states[re.index]
+	exitErr := re.exitErr()
+	abnormal := re.panic != nil || (re.err != nil && !isCancelErr(state.ctx, re.err))
+	state.running = false
+	state.cancel()
+	r.running--
+
+	if abnormal {
+		logWarn(r.logger, "brun: supervisor child exited",
+			"child", re.name,
+			"err", exitErr)
+	}
+
+	if r.stopping {
+		return
+	}
+	if state.restarting {
+		r.startRestarted()
+		return
+	}
+
+	spec := r.children[re.index]
+	if spec.Restart == Temporary || (spec.Restart == Transient && !abnormal) {
+		state.active = false
+		return
+	}
+
+	if !r.allowRestart() {
+		r.failErr = &RestartLimitError{
+			Child:       re.name,
+			MaxRestarts: r.maxRestarts,
+			Window:      r.window,
+			Err:         exitErr,
+		}
+		r.stop()
+		return
+	}
+
+	r.metrics.restarted()
+	switch r.strategy {
+	case OneForAll:
+		r.restartFrom(0, re.index)
+	case RestForOne:
+		r.restartFrom(re.index, re.index)
+	default:
+		r.start(re.index)
+	}
+}
+
+// allowRestart records a restart, and returns false if it exceeds the restart
+// intensity.
+func (r *supervisorRun) allowRestart() bool {
+	now := getClock(r.ctx).Now()
+	cutoff := now.Add(-r.window)
+	recent := r.restarts[:0]
+	for _, t := range r.restarts {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	r.restarts = append(recent, now)
+	return len(r.restarts) <= r.maxRestarts
+}
+
+// restartFrom stops every active child from the given index onwards, besides
+// the one that exited, and restarts them all once they have exited. Temporary
+// children are stopped but not restarted.
+func (r *supervisorRun) restartFrom(from, exited int) {
+	for i := from; i < len(r.states); i++ {
+		state := &r.states[i]
+		if !state.active {
+			continue
+		}
+		if r.children[i].Restart == Temporary && i != exited {
+			if state.running {
+				state.cancel()
+			}
+			state.active = false
+			continue
+		}
+		state.restarting = true
+		if state.running {
+			state.cancel()
+		}
+	}
+	r.startRestarted()
+}
+
+// startRestarted starts the children waiting to be restarted, once none of
+// them are still running.
+func (r *supervisorRun) startRestarted() {
+	for _, state := range r.states {
+		if state.restarting && state.running {
+			return
+		}
+	}
+	for i := range r.states {
+		if r.states[i].restarting {
+			r.start(i)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package brun
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bennettjames/go-concurrency-experiments/brun/bruntest"
+)
+
+func Test_Supervisor(t *testing.T) {
+	// counted returns a child that counts its starts in the given counter, and
+	// exits with the results of fail on its first start and otherwise waits to
+	// be cancelled.
+	counted := func(starts *int32, fail func() error) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			if atomic.AddInt32(starts, 1) == 1 && fail != nil {
+				return fail()
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		}
+	}
+	failure := func() error { return errors.New("failure") }
+
+	runFor := func(s *Supervisor, d time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+		go func() {
+			time.Sleep(d)
+			cancel()
+		}()
+		return s.Run(ctx)
+	}
+
+	t.Run("oneForOne", func(t *testing.T) {
+		var a, b int32
+		s := Supervisor{Strategy: OneForOne}
+		s.Add(ChildSpec{Name: "a", Run: counted(&a, failure)})
+		s.Add(ChildSpec{Name: "b", Run: counted(&b, nil)})
+
+		if err := runFor(&s, 20*time.Millisecond); err != context.Canceled {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if a != 2 || b != 1 {
+			t.Fatalf("unexpected starts: a=%d, b=%d", a, b)
+		}
+	})
+
+	t.Run("oneForAll", func(t *testing.T) {
+		var a, b, tmp int32
+		s := Supervisor{Strategy: OneForAll}
+		s.Add(ChildSpec{Name: "a", Run: counted(&a, nil)})
+		s.Add(ChildSpec{Name: "b", Run: counted(&b, failure)})
+		s.Add(ChildSpec{Name: "tmp", Run: counted(&tmp, nil), Restart: Temporary})
+
+		if err := runFor(&s, 20*time.Millisecond); err != context.Canceled {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if a != 2 || b != 2 || tmp != 1 {
+			t.Fatalf("unexpected starts: a=%d, b=%d, tmp=%d", a, b, tmp)
+		}
+	})
+
+	t.Run("restForOne", func(t *testing.T) {
+		var a, b, c int32
+		s := Supervisor{Strategy: RestForOne}
+		s.Add(ChildSpec{Name: "a", Run: counted(&a, nil)})
+		s.Add(ChildSpec{Name: "b", Run: counted(&b, failure)})
+		s.Add(ChildSpec{Name: "c", Run: counted(&c, nil)})
+
+		if err := runFor(&s, 20*time.Millisecond); err != context.Canceled {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if a != 1 || b != 2 || c != 2 {
+			t.Fatalf("unexpected starts: a=%d, b=%d, c=%d", a, b, c)
+		}
+	})
+
+	t.Run("restartPolicies", func(t *testing.T) {
+		exitNil := func() error { return nil }
+		var permanent, transientOK, transientFail, temporary int32
+		s := Supervisor{}
+		s.Add(ChildSpec{Run: counted(&permanent, exitNil), Restart: Permanent})
+		s.Add(ChildSpec{Run: counted(&transientOK, exitNil), Restart: Transient})
+		s.Add(ChildSpec{Run: counted(&transientFail, failure), Restart: Transient})
+		s.Add(ChildSpec{Run: counted(&temporary, failure), Restart: Temporary})
+
+		if err := runFor(&s, 20*time.Millisecond); err != context.Canceled {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if permanent != 2 || transientOK != 1 || transientFail != 2 || temporary != 1 {
+			t.Fatalf(
+				"unexpected starts: permanent=%d, transientOK=%d, transientFail=%d, temporary=%d",
+				permanent, transientOK, transientFail, temporary)
+		}
+	})
+
+	t.Run("recoversPanics", func(t *testing.T) {
+		var starts int32
+		s := Supervisor{Logger: MuteLogger}
+		s.Add(ChildSpec{
+			Name:    "panicky",
+			Restart: Transient,
+			Run: counted(&starts, func() error {
+				panic("oh no")
+			}),
+		})
+
+		if err := runFor(&s, 20*time.Millisecond); err != context.Canceled {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if starts != 2 {
+			t.Fatalf("unexpected starts: %d", starts)
+		}
+	})
+
+	t.Run("failsPastRestartIntensity", func(t *testing.T) {
+		var l sync.Mutex
+		stillRunning := false
+		s := Supervisor{MaxRestarts: 2, Window: 1 * time.Second}
+		s.Add(ChildSpec{
+			Name: "flaky",
+			Run: func(ctx context.Context) error {
+				return failure()
+			},
+		})
+		s.Add(ChildSpec{
+			Name: "steady",
+			Run: func(ctx context.Context) error {
+				l.Lock()
+				stillRunning = true
+				l.Unlock()
+				<-ctx.Done()
+				l.Lock()
+				stillRunning = false
+				l.Unlock()
+				return ctx.Err()
+			},
+		})
+
+		err := runFor(&s, 500*time.Millisecond)
+		var limitErr *RestartLimitError
+		if !errors.As(err, &limitErr) {
+			t.Fatalf("expected a restart limit error; got %v", err)
+		}
+		if limitErr.Child != "flaky" || limitErr.Err.Error() != "failure" {
+			t.Fatalf("unexpected restart limit error: %v", limitErr)
+		}
+		l.Lock()
+		defer l.Unlock()
+		if stillRunning {
+			t.Fatalf("expected every child to have exited")
+		}
+	})
+
+	t.Run("defaultsIntensitySeparately", func(t *testing.T) {
+		var starts int32
+		s := Supervisor{MaxRestarts: 1}
+		s.Add(ChildSpec{
+			Run: func(ctx context.Context) error {
+				atomic.AddInt32(&starts, 1)
+				return failure()
+			},
+		})
+
+		var limitErr *RestartLimitError
+		if err := runFor(&s, 500*time.Millisecond); !errors.As(err, &limitErr) {
+			t.Fatalf("expected a restart limit error; got %v", err)
+		}
+		if limitErr.MaxRestarts != 1 || limitErr.Window != 5*time.Second || starts != 2 {
+			t.Fatalf("unexpected limit after %d starts: %v", starts, limitErr)
+		}
+	})
+
+	t.Run("negativeMaxRestartsAllowsNone", func(t *testing.T) {
+		var starts int32
+		s := Supervisor{MaxRestarts: -1}
+		s.Add(ChildSpec{
+			Run: func(ctx context.Context) error {
+				atomic.AddInt32(&starts, 1)
+				return failure()
+			},
+		})
+
+		var limitErr *RestartLimitError
+		if err := runFor(&s, 500*time.Millisecond); !errors.As(err, &limitErr) {
+			t.Fatalf("expected a restart limit error; got %v", err)
+		}
+		if starts != 1 {
+			t.Fatalf("expected no restarts; got %d starts", starts)
+		}
+	})
+
+	t.Run("restartsOutsideWindowAreForgotten", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		clock := bruntest.NewFakeClock(time.Unix(0, 0))
+		ctx = WithClock(ctx, clock)
+
+		var starts int32
+		s := Supervisor{MaxRestarts: 1, Window: 5 * time.Millisecond}
+		s.Add(ChildSpec{
+			Run: func(ctx context.Context) error {
+				if atomic.AddInt32(&starts, 1) > 3 {
+					cancel()
+					<-ctx.Done()
+					return ctx.Err()
+				}
+				clock.Advance(10 * time.Millisecond)
+				return failure()
+			},
+		})
+
+		if err := s.Run(ctx); err != context.Canceled {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if starts != 4 {
+			t.Fatalf("unexpected starts: %d", starts)
+		}
+	})
+}
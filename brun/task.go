@@ -0,0 +1,147 @@
+package brun
+
+import (
+	"context"
+	"sync"
+)
+
+// Task is a handle on a single function added to a Set. It can be used to stop
+// that function alone, and to learn when and how it exited.
+type Task struct {
+	// goroutineID is the ID of the goroutine running the task, recorded when
+	// the set needs to be able to report the task's stack. Accessed
+	// atomically, and kept first for alignment.
+	goroutineID int64
+
+	fn   func(context.Context)
+	done chan struct{}
+
+	// key is the key the task was added under, if any.
+	key string
+	// prev is the task this one replaced under its key. The task will not start
+	// running its function until prev is done.
+	prev *Task
+
+	// priority and seq determine the task's place in the set's queue.
+	priority int
+	seq      uint64
+
+	l         sync.Mutex
+	ctx       context.Context
+	cancel    context.CancelFunc
+	cancelled bool
+	err       error
+}
+
+func newTask(fn func(context.Context)) *Task {
+	return &Task{
+		fn:   fn,
+		done: make(chan struct{}),
+	}
+}
+
+// Cancel stops the task. If it is running, its context is cancelled; if it has
+// yet to start, it never will be and is immediately marked as done. Cancelling
+// a task that has already exited does nothing.
+func (t *Task) Cancel() {
+	t.l.Lock()
+	defer t.l.Unlock()
+	if t.cancelled {
+		return
+	}
+	t.cancelled = true
+	if t.cancel != nil {
+		t.cancel()
+		return
+	}
+	t.err = context.Canceled
+	close(t.done)
+}
+
+// Done returns a channel that is closed once the task has exited, or has been
+// cancelled or had its set stop before it could start.
+func (t *Task) Done() <-chan struct{} {
+	return t.done
+}
+
+// Err returns why the task exited. It is nil while the task is running or if
+// its function returned of its own accord, the context error if it exited
+// after being cancelled (either individually or by the set shutting down), and
+// a *PanicError if it panicked.
+func (t *Task) Err() error {
+	t.l.Lock()
+	defer t.l.Unlock()
+	return t.err
+}
+
+// Key returns the key the task was added under, or an empty string if it was
+// added without one.
+func (t *Task) Key() string {
+	return t.key
+}
+
+// isDone indicates if the task has already exited.
+func (t *Task) isDone() bool {
+	select {
+	case <-t.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// run executes the task's function once the task it replaced, if any, has
+// exited. If ctx is cancelled while waiting, the function is never called.
+func (t *Task) run(ctx context.Context) {
+	if prev := t.prev; prev != nil {
+		select {
+		case <-prev.Done():
+		case <-ctx.Done():
+			return
+		}
+		// Dropping the reference keeps a long series of replacements from
+		// holding on to every task that came before.
+		t.prev = nil
+	}
+	t.fn(ctx)
+}
+
+// start prepares the task to be run under the given context. Returns false if
+// the task has already been cancelled, in which case it must not be run.
+func (t *Task) start(ctx context.Context) (context.Context, bool) {
+	t.l.Lock()
+	defer t.l.Unlock()
+	if t.cancelled {
+		return nil, false
+	}
+	t.ctx, t.cancel = context.WithCancel(ctx)
+	return t.ctx, true
+}
+
+// abandon marks a task that will never be run as done, with the given error.
+// Returns false if the task was already cancelled.
+func (t *Task) abandon(err error) bool {
+	t.l.Lock()
+	defer t.l.Unlock()
+	if t.cancelled {
+		return false
+	}
+	t.cancelled = true
+	t.err = err
+	close(t.done)
+	return true
+}
+
+// finish records the result of the task's run and marks it as done.
+func (t *Task) finish(re runErr) {
+	t.l.Lock()
+	defer t.l.Unlock()
+	if re.panic != nil {
+		t.err = re.panicError()
+	} else {
+		t.err = t.ctx.Err()
+	}
+	t.cancelled = true
+	t.cancel()
+	close(t.done)
+}
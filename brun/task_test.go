@@ -0,0 +1,78 @@
+package brun
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_Task(t *testing.T) {
+	t.Run("cancelRunning", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		set := NewSet()
+		started := make(chan struct{})
+		task := set.AddTask(func(ctx context.Context) {
+			close(started)
+			<-ctx.Done()
+		})
+		other := set.AddTask(func(ctx context.Context) {
+			<-ctx.Done()
+		})
+		go set.Run(ctx)
+
+		<-started
+		task.Cancel()
+		select {
+		case <-task.Done():
+		case <-ctx.Done():
+			t.Fatal("Expected task to exit after cancel")
+		}
+		if err := task.Err(); err != context.Canceled {
+			t.Fatalf("Expected cancel error; got %v", err)
+		}
+		select {
+		case <-other.Done():
+			t.Fatal("Expected other task to keep running")
+		default:
+		}
+	})
+
+	t.Run("cancelPending", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		set := NewSet()
+		task := set.AddTask(func(ctx context.Context) {
+			t.Error("Cancelled task should never run")
+		})
+		task.Cancel()
+		<-task.Done()
+
+		done := set.AddTask(func(ctx context.Context) {})
+		go set.Run(ctx)
+		<-done.Done()
+		if err := done.Err(); err != nil {
+			t.Fatalf("Expected no error from completed task; got %v", err)
+		}
+	})
+
+	t.Run("panicErr", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		set := NewSet()
+		set.PanicsAsErrors = true
+		task := set.AddTask(func(ctx context.Context) {
+			panic("task panic")
+		})
+		set.Run(ctx)
+
+		var panicErr *PanicError
+		if !errors.As(task.Err(), &panicErr) {
+			t.Fatalf("Expected panic error; got %v", task.Err())
+		}
+	})
+}
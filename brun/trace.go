@@ -0,0 +1,40 @@
+package brun
+
+import (
+	"context"
+	"runtime/trace"
+	"strconv"
+)
+
+// Execution traces show each run of a primitive as a task named "brun.group",
+// "brun.batch", "brun.set" or "brun.supervisor", with a child task for each
+// member named after the primitive, e.g. "brun.group.member". Retry attempts
+// and the waits between them are regions named "brun.retry.attempt" and
+// "brun.retry.backoff".
+const (
+	regionRetryAttempt = "brun.retry.attempt"
+	regionRetryBackoff = "brun.retry.backoff"
+)
+
+// traceRun starts the trace task for a run of a primitive of the given kind.
+func traceRun(ctx context.Context, kind string) (context.Context, *trace.Task) {
+	return trace.NewTask(ctx, "brun."+kind)
+}
+
+// traceMember starts the trace task for a member of a primitive of the given
+// kind, logging which member it is.
+func traceMember(
+	ctx context.Context,
+	kind, member string,
+) (context.Context, *trace.Task) {
+	ctx, task := trace.NewTask(ctx, "brun."+kind+".member")
+	trace.Log(ctx, LabelMember, member)
+	return ctx, task
+}
+
+// traceAttempt logs the number of a retry attempt, if tracing.
+func traceAttempt(ctx context.Context, attempt int) {
+	if trace.IsEnabled() {
+		trace.Log(ctx, "brun.retry.attempt", strconv.Itoa(attempt))
+	}
+}
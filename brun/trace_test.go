@@ -0,0 +1,53 @@
+package brun
+
+import (
+	"bytes"
+	"context"
+	"runtime/trace"
+	"testing"
+	"time"
+)
+
+func Test_Trace(t *testing.T) {
+	var buf bytes.Buffer
+	if err := trace.Start(&buf); err != nil {
+		t.Skipf("unable to start trace: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	attempts := 0
+	retried := make(chan struct{})
+	g := Group{}
+	g.AddNamed("api", func(ctx context.Context) error {
+		<-retried
+		return BatchRun(ctx, func(ctx context.Context) error {
+			return nil
+		})
+	})
+	g.AddNamed("poller", GapRetryErr(10*time.Millisecond, func(ctx context.Context) error {
+		attempts++
+		if attempts < 2 {
+			return nil
+		}
+		close(retried)
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+	g.Run(ctx)
+	trace.Stop()
+
+	for _, name := range []string{
+		"brun.group",
+		"brun.group.member",
+		"brun.batch",
+		"brun.batch.member",
+		regionRetryAttempt,
+		regionRetryBackoff,
+	} {
+		if !bytes.Contains(buf.Bytes(), []byte(name)) {
+			t.Fatalf("expected trace to include %q", name)
+		}
+	}
+}